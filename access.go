@@ -0,0 +1,249 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Roles carried in the "role" attribute of the client certificate
+const (
+	RolePlatformAdmin = "platformAdmin"
+	RoleTheatreAdmin  = "theatreAdmin"
+	RoleCashier       = "cashier"
+	RoleCustomer      = "customer"
+)
+
+// Roles that may call each Invoke function
+var functionRoles = map[string][]string{
+	"init":                             {RolePlatformAdmin},
+	"read":                             {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"generic_query":                    {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"generic_query_pagination":         {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"getHistory":                       {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"invoke_transaction_insert_update": {RolePlatformAdmin},
+	"add_theatre":                      {RolePlatformAdmin},
+	"add_movies":                       {RoleTheatreAdmin},
+	"add_shows":                        {RoleTheatreAdmin},
+	"book_tickets":                     {RoleCustomer, RoleCashier},
+	"hold_seats":                       {RoleCustomer, RoleCashier},
+	"confirm_hold":                     {RoleCustomer, RoleCashier},
+	"release_hold":                     {RoleCustomer, RoleCashier},
+	"exchange_water":                   {RoleCustomer, RoleCashier},
+	"get_seat_map":                     {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"cancel_ticket":                    {RoleCustomer, RoleCashier},
+	"transfer_ticket":                  {RoleCustomer, RoleCashier},
+	"list_ticket_for_resale":           {RoleCustomer, RoleCashier},
+	"cancel_resale":                    {RoleCustomer, RoleCashier},
+	"buy_resale_ticket":                {RoleCustomer},
+	"get_resale_tickets":               {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"check_in_ticket":                  {RoleTheatreAdmin, RoleCashier},
+	"get_show_admissions":              {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier},
+	"get_gate_snapshot":                {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier},
+	"join_waitlist":                    {RoleCustomer},
+	"leave_waitlist":                   {RoleCustomer},
+	"get_waitlist":                     {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"expire_offers":                    {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier},
+	"set_refund_policy":                {RolePlatformAdmin},
+	"get_show":                         {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"get_theatre_shows":                {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"get_theatre_movies":               {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"migrate_keys":                     {RolePlatformAdmin},
+	"get_my_tickets":                   {RoleCustomer, RoleCashier},
+	"set_price_plan":                   {RoleTheatreAdmin},
+	"get_price_plan":                   {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"set_config":                       {RolePlatformAdmin},
+	"get_config":                       {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"add_screen":                       {RoleTheatreAdmin},
+	"get_theatre_screens":              {RolePlatformAdmin, RoleTheatreAdmin, RoleCashier, RoleCustomer},
+	"get_screen_utilization":           {RolePlatformAdmin, RoleTheatreAdmin},
+	"reschedule_show":                  {RoleTheatreAdmin},
+	"cancel_show":                      {RoleTheatreAdmin},
+	"process_refund_batch":             {RolePlatformAdmin, RoleTheatreAdmin},
+	"get_job":                          {RolePlatformAdmin, RoleTheatreAdmin},
+	"refresh_movie_status":             {RoleTheatreAdmin},
+	"end_movie_run":                    {RoleTheatreAdmin},
+	"remove_movie":                     {RoleTheatreAdmin},
+}
+
+// Error codes returned when access is refused
+const (
+	ErrAccessDenied = "ACCESS_DENIED"
+)
+
+// Caller Struct - client identity submitting the transaction
+type Caller struct {
+	MspId        string `json:"mspId"`
+	Id           string `json:"id"`
+	Role         string `json:"role"`
+	TheatreRegNo string `json:"theatreRegNo"`
+}
+
+// Platform Struct - organisation whose platform admins run the chaincode, recorded by Init
+type Platform struct {
+	ObjectType string `json:"docType"` // field defined for couchdb
+	MspId      string `json:"mspId"`
+}
+
+// ============================================================================================================================
+// get_caller() - read the client identity from the certificate of the creator
+//
+// The theatre of theatre admins and cashiers comes from the "theatreRegNo" attribute,
+// and falls back to the certificate common name for identities enrolled without it.
+// ============================================================================================================================
+func get_caller(stub shim.ChaincodeStubInterface) (Caller, error) {
+	var caller Caller
+	identity, err := cid.New(stub)
+	if err != nil {
+		return caller, err
+	}
+	caller.MspId, err = identity.GetMSPID()
+	if err != nil {
+		return caller, err
+	}
+	caller.Id, err = identity.GetID()
+	if err != nil {
+		return caller, err
+	}
+	caller.Role, _, err = identity.GetAttributeValue("role")
+	if err != nil {
+		return caller, err
+	}
+	theatreRegNo, found, err := identity.GetAttributeValue("theatreRegNo")
+	if err != nil {
+		return caller, err
+	}
+	if found && theatreRegNo != "" {
+		caller.TheatreRegNo = theatreRegNo
+	} else if caller.Role == RoleTheatreAdmin || caller.Role == RoleCashier {
+		certname, err := get_cert(stub)
+		if err != nil {
+			return caller, err
+		}
+		caller.TheatreRegNo = string(certname)
+	}
+	return caller, nil
+}
+
+// Owner id of the caller - hash of the MSP ID and certificate subject, so tickets
+// name their owner without putting the certificate details on the ledger
+func (caller Caller) OwnerId() string {
+	digest := sha256.Sum256([]byte(caller.MspId + "::" + caller.Id))
+	return hex.EncodeToString(digest[:])
+}
+
+// Reads the platform organisation recorded by Init
+func getPlatform(stub shim.ChaincodeStubInterface) (Platform, error) {
+	var platform Platform
+	platformAsBytes, err := getEntity(stub, keyPlatform)
+	if err != nil {
+		return platform, err
+	}
+	if platformAsBytes == nil {
+		return platform, errors.New("Platform organisation is not set, chaincode needs to be initialised")
+	}
+	err = json.Unmarshal(platformAsBytes, &platform)
+	return platform, err
+}
+
+// Records the organisation of the caller as the platform organisation
+func putPlatform(stub shim.ChaincodeStubInterface) error {
+	mspId, err := cid.GetMSPID(stub)
+	if err != nil {
+		return err
+	}
+	var platform Platform
+	platform.ObjectType = "Platform"
+	platform.MspId = mspId
+	return putEntity(stub, platform, keyPlatform)
+}
+
+// Reads the organisation of the staff of a theatre. Theatres added before it was recorded are
+// run by the platform organisation.
+func getTheatreMspId(stub shim.ChaincodeStubInterface, theatreRegNo string) (string, error) {
+	theatreAsBytes, err := getEntity(stub, keyTheatre, theatreRegNo)
+	if err != nil {
+		return "", err
+	}
+	if theatreAsBytes == nil {
+		return "", errors.New("This theatre does not exists - " + theatreRegNo)
+	}
+	var theatre Theatre
+	json.Unmarshal(theatreAsBytes, &theatre)
+	if theatre.MspId != "" {
+		return theatre.MspId, nil
+	}
+	platform, err := getPlatform(stub)
+	return platform.MspId, err
+}
+
+// Checks that the caller holds one of the roles declared for the function. Platform admins
+// are only accepted from the platform organisation and theatre staff from the organisation of
+// their theatre, so no other organisation's CA can mint them.
+func authorize(stub shim.ChaincodeStubInterface, function string) error {
+	caller, err := get_caller(stub)
+	if err != nil {
+		return errors.New("Error retrieving client identity : " + err.Error())
+	}
+	roles, declared := functionRoles[function]
+	if !declared {
+		return nil // unknown functions are rejected by Invoke
+	}
+	if !hasRole(roles, caller.Role) {
+		return errors.New("Role '" + caller.Role + "' cannot call " + function + ", allowed roles are " + strings.Join(roles, ", "))
+	}
+	if caller.Role == RolePlatformAdmin {
+		platform, err := getPlatform(stub)
+		if err != nil {
+			return err
+		}
+		if platform.MspId != caller.MspId {
+			return errors.New("Platform admins must belong to " + platform.MspId)
+		}
+	}
+	if caller.Role == RoleTheatreAdmin || caller.Role == RoleCashier {
+		if caller.TheatreRegNo == "" {
+			return errors.New("Theatre staff identity carries no theatre")
+		}
+		mspId, err := getTheatreMspId(stub, caller.TheatreRegNo)
+		if err != nil {
+			return err
+		}
+		if mspId != caller.MspId {
+			return errors.New("Staff of theatre " + caller.TheatreRegNo + " must belong to " + mspId)
+		}
+	}
+	return nil
+}
+
+// Whether role is one of roles
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Staff are only accepted from the organisation of their theatre, platform admins from the platform's
+func TestTheatreStaffOrganisation(t *testing.T) {
+	l := newTestLedger(t, 10, 10)
+	l.ok(l.admin, "add_theatre", `{"theatreRegNo":"TH2","theatreName":"Other","numberOfScreens":1,"docType":"Theatre","mspId":"Org2MSP"}`)
+	owner := benchIdentity("Org2MSP", "TH2", map[string]string{"role": "theatreAdmin", "theatreRegNo": "TH2"})
+	forged := benchIdentity("Org1MSP", "TH2", map[string]string{"role": "theatreAdmin", "theatreRegNo": "TH2"})
+	forgedTH1 := benchIdentity("Org2MSP", "TH1", map[string]string{"role": "cashier", "theatreRegNo": "TH1"})
+
+	response := l.fail(forged, "add_movies", `{"movieId":"M2","movieName":"Film"}`)
+	if !strings.Contains(response.Message, ErrAccessDenied) {
+		t.Fatal(response.Message)
+	}
+	l.ok(owner, "add_movies", `{"movieId":"M2","movieName":"Film"}`)
+	l.fail(forgedTH1, "book_tickets", `{"showId":"S1","seats":["A1"]}`)
+	l.ok(l.cashier, "book_tickets", `{"showId":"S1","seats":["A1"]}`)
+
+	l.fail(benchIdentity("Org2MSP", "admin", map[string]string{"role": "platformAdmin"}), "set_config", `{"config":{"sodaPerDay":1}}`)
+	l.fail(l.alice, "add_movies", `{"movieId":"M3","movieName":"Film"}`)
+	l.fail(benchIdentity("Org1MSP", "TH9", map[string]string{"role": "theatreAdmin"}), "add_movies", `{"movieId":"M3","movieName":"Film"}`)
+}
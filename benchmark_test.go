@@ -0,0 +1,432 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Booking benchmark:
+//
+//   go test -run XXX -bench Bookings .
+//
+// Concurrent buyers book different seats of one show. Every booking of a block is simulated
+// against the same committed state, then the block is validated in order the way the peer does -
+// a transaction whose reads were changed by an earlier valid one fails with MVCC_READ_CONFLICT
+// (or PHANTOM_READ_CONFLICT for range reads) and its buyer retries in the next block. It is run
+// with the per seat keys, then again with each booking also rewriting the Shows and SeatMap
+// documents as book_tickets did before.
+//
+// The mock ledger of the benchmark also runs the tests, one transaction per block.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Buyers booking at the same time, one seat each, all sent in one block
+const benchBuyers = 100
+
+// benchLedger - committed state with the version of every key
+type benchLedger struct {
+	state    map[string][]byte
+	versions map[string]uint64
+	version  uint64
+	txs      int
+	events   []*pb.ChaincodeEvent // events of the committed transactions
+}
+
+// benchRange - range read by a transaction and the keys it saw. Like on the peer only the keys
+// iterated are kept, a range left before its end is checked up to the last key read.
+type benchRange struct {
+	start     string
+	end       string
+	keys      map[string]uint64
+	last      string
+	exhausted bool
+}
+
+// benchTx - one transaction simulated against the committed state. Like an endorsing peer it
+// keeps the version of every key read and buffers the writes until the block is committed.
+type benchTx struct {
+	*shim.MockStub // composite key helpers
+	ledger         *benchLedger
+	txId           string
+	args           [][]byte
+	creator        []byte
+	now            time.Time
+	reads          map[string]uint64
+	ranges         []*benchRange
+	writes         map[string][]byte // nil for a deleted key
+	event          *pb.ChaincodeEvent
+	response       pb.Response
+}
+
+// benchIterator - results of a range read, adding the keys to the range as they are read
+type benchIterator struct {
+	r   *benchRange
+	tx  *benchTx
+	kvs []*queryresult.KV
+	i   int
+}
+
+func (it *benchIterator) HasNext() bool { return it.i < len(it.kvs) }
+func (it *benchIterator) Close() error  { return nil }
+func (it *benchIterator) Next() (*queryresult.KV, error) {
+	kv := it.kvs[it.i]
+	it.i++
+	it.r.keys[kv.Key] = it.tx.ledger.versions[kv.Key]
+	it.r.last = kv.Key
+	it.r.exhausted = it.i == len(it.kvs)
+	return kv, nil
+}
+
+func newBenchLedger() *benchLedger {
+	return &benchLedger{state: make(map[string][]byte), versions: make(map[string]uint64)}
+}
+
+// Keys of the committed state inside [start, end)
+func (l *benchLedger) keysInRange(start string, end string) []string {
+	var keys []string
+	for key := range l.state {
+		if key >= start && (end == "" || key < end) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Simulates a transaction - runs the chaincode and keeps its read and write sets
+func (l *benchLedger) simulate(creator []byte, now time.Time, args ...string) *benchTx {
+	l.txs++
+	tx := &benchTx{MockStub: shim.NewMockStub("mta", new(MTA)), ledger: l, creator: creator, now: now}
+	tx.txId = fmt.Sprintf("benchtx%08d", l.txs)
+	tx.reads = make(map[string]uint64)
+	tx.writes = make(map[string][]byte)
+	for _, arg := range args {
+		tx.args = append(tx.args, []byte(arg))
+	}
+	if args[0] == "init" {
+		tx.response = new(MTA).Init(tx)
+	} else {
+		tx.response = new(MTA).Invoke(tx)
+	}
+	return tx
+}
+
+// Reads and rewrites a key, the way bookings used to update the show and its seat map
+func (tx *benchTx) touch(objectType string, attributes ...string) {
+	key, _ := tx.CreateCompositeKey(objectType, attributes)
+	value, _ := tx.GetState(key)
+	tx.writes[key] = value
+}
+
+// Validates the transactions of a block in order and commits the valid ones. Gives the
+// transactions which failed validation.
+func (l *benchLedger) commit(block []*benchTx) []*benchTx {
+	var invalid []*benchTx
+	for _, tx := range block {
+		if !l.valid(tx) {
+			invalid = append(invalid, tx)
+			continue
+		}
+		for key, value := range tx.writes {
+			l.version++
+			if value == nil {
+				delete(l.state, key)
+				delete(l.versions, key)
+				continue
+			}
+			l.state[key] = value
+			l.versions[key] = l.version
+		}
+		if tx.event != nil {
+			l.events = append(l.events, tx.event)
+		}
+	}
+	return invalid
+}
+
+// Runs one transaction in a block of its own, the way the tests call the chaincode
+func (l *benchLedger) invoke(creator []byte, now time.Time, args ...string) pb.Response {
+	tx := l.simulate(creator, now, args...)
+	l.commit([]*benchTx{tx})
+	return tx.response
+}
+
+// Tells if every key read by the transaction, and the result of every range it read, is
+// still what the committed state holds
+func (l *benchLedger) valid(tx *benchTx) bool {
+	if tx.response.Status != shim.OK {
+		return false
+	}
+	for key, version := range tx.reads {
+		if l.versions[key] != version {
+			return false
+		}
+	}
+	for _, r := range tx.ranges {
+		var keys []string
+		for _, key := range l.keysInRange(r.start, r.end) {
+			if r.exhausted || key <= r.last {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) != len(r.keys) {
+			return false
+		}
+		for _, key := range keys {
+			if version, ok := r.keys[key]; !ok || l.versions[key] != version {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ChaincodeStubInterface, reading the committed state and keeping the writes
+
+func (tx *benchTx) GetArgs() [][]byte { return tx.args }
+
+func (tx *benchTx) GetStringArgs() []string {
+	var strs []string
+	for _, arg := range tx.args {
+		strs = append(strs, string(arg))
+	}
+	return strs
+}
+
+func (tx *benchTx) GetFunctionAndParameters() (string, []string) {
+	strs := tx.GetStringArgs()
+	return strs[0], strs[1:]
+}
+
+func (tx *benchTx) GetTxID() string { return tx.txId }
+
+func (tx *benchTx) GetCreator() ([]byte, error) { return tx.creator, nil }
+
+func (tx *benchTx) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: tx.now.Unix(), Nanos: int32(tx.now.Nanosecond())}, nil
+}
+
+func (tx *benchTx) SetEvent(name string, payload []byte) error {
+	tx.event = &pb.ChaincodeEvent{EventName: name, Payload: payload}
+	return nil
+}
+
+func (tx *benchTx) GetState(key string) ([]byte, error) {
+	tx.reads[key] = tx.ledger.versions[key]
+	return tx.ledger.state[key], nil
+}
+
+func (tx *benchTx) PutState(key string, value []byte) error {
+	tx.writes[key] = value
+	return nil
+}
+
+func (tx *benchTx) DelState(key string) error {
+	tx.writes[key] = nil
+	return nil
+}
+
+func (tx *benchTx) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	r := &benchRange{start: startKey, end: endKey, keys: make(map[string]uint64)}
+	it := &benchIterator{r: r, tx: tx}
+	for _, key := range tx.ledger.keysInRange(startKey, endKey) {
+		it.kvs = append(it.kvs, &queryresult.KV{Key: key, Value: tx.ledger.state[key]})
+	}
+	r.exhausted = len(it.kvs) == 0
+	tx.ranges = append(tx.ranges, r)
+	return it, nil
+}
+
+func (tx *benchTx) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := tx.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return tx.GetStateByRange(prefix, prefix+string(rune(0x10FFFF)))
+}
+
+// Paginated queries are only allowed in read-only transactions on the peer, their range is not validated
+func (tx *benchTx) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	prefix, err := tx.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	start := prefix
+	if bookmark != "" {
+		start = bookmark
+	}
+	it := &benchIterator{r: &benchRange{keys: make(map[string]uint64)}, tx: tx}
+	next := ""
+	for _, key := range tx.ledger.keysInRange(start, prefix+string(rune(0x10FFFF))) {
+		if len(it.kvs) == int(pageSize) {
+			next = key
+			break
+		}
+		it.kvs = append(it.kvs, &queryresult.KV{Key: key, Value: tx.ledger.state[key]})
+	}
+	return it, &pb.QueryResponseMetadata{FetchedRecordsCount: int32(len(it.kvs)), Bookmark: next}, nil
+}
+
+// Enrollment of a client - serialized identity with a certificate carrying the role attributes
+func benchIdentity(mspId string, name string, attrs map[string]string) []byte {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	attrsAsBytes, _ := json.Marshal(map[string]interface{}{"attrs": attrs})
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: name},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}, Value: attrsAsBytes}},
+	}
+	certAsBytes, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certAsBytes})
+	creator, _ := proto.Marshal(&msp.SerializedIdentity{Mspid: mspId, IdBytes: certPem})
+	return creator
+}
+
+// Result of one run of the benchmark
+type benchResult struct {
+	Design    string
+	Blocks    int
+	Committed int
+	Conflicts int
+	FirstPass int
+}
+
+// Books one seat for each buyer, sending every booking not committed yet in the next block
+func benchBookings(design string, sharedShow bool) (benchResult, error) {
+	result := benchResult{Design: design}
+	ledger := newBenchLedger()
+	now := time.Date(2019, 12, 20, 9, 0, 0, 0, time.UTC)
+	admin := benchIdentity("Org1MSP", "admin", map[string]string{"role": "platformAdmin"})
+	theatreAdmin := benchIdentity("Org1MSP", "TH1", map[string]string{"role": "theatreAdmin", "theatreRegNo": "TH1"})
+
+	setup := [][]string{
+		{"init", "1"},
+		{"add_theatre", `{"theatreRegNo":"TH1","theatreName":"Bench","numberOfScreens":1,"docType":"Theatre"}`},
+		{"add_movies", `{"movieId":"M1","movieName":"Bench"}`},
+		{"add_shows", `{"showId":"S1","showTiming":"2019-12-29 10:00am","movieId":"M1","docType":"Shows"}`},
+	}
+	for i, args := range setup {
+		creator := theatreAdmin
+		if i < 2 {
+			creator = admin
+		}
+		response := ledger.invoke(creator, now, args...)
+		if response.Status != shim.OK {
+			return result, fmt.Errorf("setup failed at %s : %s", args[0], response.Message)
+		}
+	}
+
+	// every buyer has an identity of their own, the purchase counters of one buyer are shared by their bookings
+	cfg := defaultConfig()
+	var pending []string
+	buyerOf := make(map[string][]byte)
+	for i := 0; i < benchBuyers && i < cfg.DefaultSeatRows*cfg.DefaultSeatColumns; i++ {
+		seatId := rowLabel(i/cfg.DefaultSeatColumns) + strconv.Itoa(i%cfg.DefaultSeatColumns+1)
+		pending = append(pending, seatId)
+		buyerOf[seatId] = benchIdentity("Org1MSP", "buyer"+strconv.Itoa(i), map[string]string{"role": "customer"})
+	}
+
+	for len(pending) > 0 {
+		var block []*benchTx
+		seatOf := make(map[*benchTx]string)
+		for _, seatId := range pending {
+			tx := ledger.simulate(buyerOf[seatId], now, "book_tickets", `{"showId":"S1","seats":["`+seatId+`"]}`)
+			if sharedShow {
+				tx.touch(keyShow, "S1")
+				tx.touch(keySeatMap, "S1")
+			}
+			block = append(block, tx)
+			seatOf[tx] = seatId
+		}
+		invalid := ledger.commit(block)
+		result.Blocks++
+		result.Committed += len(block) - len(invalid)
+		result.Conflicts += len(invalid)
+		if result.Blocks == 1 {
+			result.FirstPass = len(block) - len(invalid)
+		}
+		pending = nil
+		for _, tx := range invalid {
+			if tx.response.Status != shim.OK {
+				return result, fmt.Errorf("booking failed : %s", tx.response.Message)
+			}
+			pending = append(pending, seatOf[tx])
+		}
+		now = now.Add(2 * time.Second)
+	}
+	return result, nil
+}
+
+// Reports the blocks and MVCC retries taken to commit the bookings, with the per seat keys and with
+// a shared show document
+func BenchmarkBookings(b *testing.B) {
+	designs := []struct {
+		name       string
+		sharedShow bool
+	}{
+		{"seat keys", false},
+		{"shared show document", true},
+	}
+	for _, design := range designs {
+		b.Run(design.name, func(b *testing.B) {
+			var r benchResult
+			var err error
+			for i := 0; i < b.N; i++ {
+				r, err = benchBookings(design.name, design.sharedShow)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(r.Blocks), "blocks")
+			b.ReportMetric(float64(r.Conflicts), "mvcc-retries")
+			b.ReportMetric(float64(r.Committed)/float64(r.Blocks), "bookings/block")
+		})
+	}
+}
+
+// Bookings of different seats of a show must not conflict
+func TestBookingsCommitTogether(t *testing.T) {
+	r, err := benchBookings("seat keys", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Blocks != 1 || r.Committed != benchBuyers || r.Conflicts != 0 {
+		t.Fatalf("%d bookings took %d blocks with %d retries, expected 1 block", r.Committed, r.Blocks, r.Conflicts)
+	}
+}
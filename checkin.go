@@ -0,0 +1,405 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+	"github.com/mpgshankar/MTA/ticketcode"
+)
+
+// ShowAdmissions Struct - seats and tickets admitted at the gate for a show
+type ShowAdmissions struct {
+	ShowId          string `json:"showId"`
+	TheatreRegNo    string `json:"theatreRegNo"`
+	CheckInStatus   string `json:"checkInStatus"` // NotOpen, Open or Closed
+	TicketsBooked   int    `json:"ticketsBooked"`
+	TicketsAdmitted int    `json:"ticketsAdmitted"`
+	SeatsBooked     int    `json:"seatsBooked"`
+	SeatsAdmitted   int    `json:"seatsAdmitted"`
+	SeatsNoShow     int    `json:"seatsNoShow"` // seats booked but not admitted, final once check-in is Closed
+}
+
+// GateSnapshot Struct - valid codes of a theatre for a day, loaded by the gates to check tickets offline
+type GateSnapshot struct {
+	TheatreRegNo string       `json:"theatreRegNo"`
+	ShowDate     string       `json:"showDate"`
+	GeneratedAt  string       `json:"generatedAt"`
+	Tickets      []GateTicket `json:"tickets"`
+}
+
+// GateTicket Struct - a ticket which can be admitted, with the check-in window of its show
+type GateTicket struct {
+	VerifyCode    string   `json:"verificationCode"`
+	TicketId      string   `json:"ticketId"`
+	ShowId        string   `json:"showId"`
+	ShowStart     string   `json:"showStart"`
+	ScreenNumber  int      `json:"screenNumber"`
+	Seats         []string `json:"seats"`
+	CheckInOpens  string   `json:"checkInOpens"`
+	CheckInCloses string   `json:"checkInCloses"`
+}
+
+// Check-in states of a show
+const (
+	CheckInNotOpen = "NotOpen"
+	CheckInOpen    = "Open"
+	CheckInClosed  = "Closed"
+)
+
+// Check-in opens checkInOpenMinutes before the show starts and closes when it ends
+func checkInWindow(show Shows, cfg Config) (time.Time, time.Time, error) {
+	start, end, err := showTimes(show, cfg)
+	return start.Add(-time.Duration(cfg.CheckInOpenMinutes) * time.Minute), end, err
+}
+
+// Check-in state of a show at the time given
+func checkInStatus(show Shows, now time.Time, cfg Config) (string, error) {
+	opens, closes, err := checkInWindow(show, cfg)
+	if err != nil {
+		return "", err
+	}
+	if now.Before(opens) {
+		return CheckInNotOpen, nil
+	}
+	if !now.Before(closes) {
+		return CheckInClosed, nil
+	}
+	return CheckInOpen, nil
+}
+
+// Verification code of a ticket - the first 80 bits of a sha256 over the ticket, its show, its
+// seats and its owner, in base32 as XXXX-XXXX-XXXX-XXXX. The code changes with the owner, so a
+// code given away with a ticket that was passed on again is no longer valid.
+func verificationCode(ticket Tickets) string {
+	seats := append([]string(nil), ticket.Seats...)
+	sort.Strings(seats)
+	digest := sha256.Sum256([]byte(ticket.TicketId + "\x00" + ticket.ShowId + "\x00" + strings.Join(seats, ",") + "\x00" + ticket.Owner))
+	code := base32.StdEncoding.EncodeToString(digest[:10])
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16]
+}
+
+// Code of a ticket, tickets booked before codes were issued get theirs computed
+func ticketCode(ticket Tickets) string {
+	if ticket.VerifyCode != "" {
+		return ticket.VerifyCode
+	}
+	return verificationCode(ticket)
+}
+
+// Reads a show from the ledger
+func getShow(stub shim.ChaincodeStubInterface, showId string) (Shows, error) {
+	show := Shows{}
+	shAsBytes, err := getEntity(stub, keyShow, showId)
+	if err != nil {
+		return show, err
+	}
+	if shAsBytes == nil {
+		return show, errors.New("This show does not exists - " + showId)
+	}
+	json.Unmarshal(shAsBytes, &show)
+	return show, nil
+}
+
+// ============================================================================================================================
+// check_in_ticket() - admit the seats of a ticket at the gate of the theatre, a ticket can be used once
+//
+// A gate which admitted the ticket offline sends the code it checked and the time it admitted the
+// ticket, the check-in window is then checked at admittedAt. Both are optional.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"ticketId":"value1","code":"ABCD-EFGH-IJKL-MNOP","admittedAt":"2019-12-29T09:45:00Z"}
+// ============================================================================================================================
+func check_in_ticket(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting check_in_ticket")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	ticketId, _ := jsonValue["ticketId"].(string)
+	code, _ := jsonValue["code"].(string)
+	admittedAtArg, _ := jsonValue["admittedAt"].(string)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	ticket, err := getTicket(stub, ticketId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	show, err := getShow(stub, ticket.ShowId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if show.TheatreRegNo != caller.TheatreRegNo {
+		return codedError(ErrAccessDenied, "Ticket "+ticketId+" is for a show of "+show.TheatreRegNo)
+	}
+	if ticket.Status == TicketRedeemed {
+		return shim.Error("This ticket was already checked in at " + ticket.CheckedInAt + " - " + ticketId)
+	}
+	if ticket.Status != TicketBooked {
+		return shim.Error("This ticket is " + ticket.Status + " - " + ticketId)
+	}
+	if show.ShowStatus == ShowCancelled {
+		return shim.Error("This show has been cancelled - " + show.ShowId)
+	}
+	if code != "" && ticketcode.Normalize(code) != ticketCode(ticket) {
+		return shim.Error("The code does not match ticket " + ticketId + ", it may have been passed on since")
+	}
+
+	cfg, err := getConfig(stub, show.TheatreRegNo)
+	if err != nil {
+		return shim.Error("Failed to check in ticket : " + err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to check in ticket : " + err.Error())
+	}
+	admittedAt := now
+	if admittedAtArg != "" {
+		admittedAt, err = time.Parse(time.RFC3339, admittedAtArg)
+		if err != nil {
+			return shim.Error("admittedAt must be an RFC3339 time - " + admittedAtArg)
+		}
+		if admittedAt.After(now) {
+			return shim.Error("admittedAt cannot be later than the transaction - " + admittedAtArg)
+		}
+		admittedAt = admittedAt.UTC()
+	}
+	status, err := checkInStatus(show, admittedAt, cfg)
+	if err != nil {
+		return shim.Error("Failed to check in ticket : " + err.Error())
+	}
+	if status == CheckInNotOpen {
+		return shim.Error("Check-in opens " + strconv.Itoa(cfg.CheckInOpenMinutes) + " minutes before show " + show.ShowId)
+	}
+	if status == CheckInClosed {
+		return shim.Error("Check-in is closed as show " + show.ShowId + " has ended")
+	}
+
+	sm, err := getSeats(stub, show.ShowId, ticket.Seats)
+	if err != nil {
+		return shim.Error("Failed to check in ticket : " + err.Error())
+	}
+	for i, seat := range sm.Seats {
+		if seat.Status != SeatSold || seat.TicketId != ticket.TicketId {
+			return shim.Error("Seat " + seat.SeatId + " is not sold to ticket " + ticket.TicketId)
+		}
+		sm.Seats[i].AdmittedAt = admittedAt.Format(time.RFC3339)
+	}
+	errSeats := putSeats(stub, sm)
+	if errSeats != nil {
+		return shim.Error("Failed to check in ticket : " + errSeats.Error())
+	}
+
+	ticket.Status = TicketRedeemed
+	ticket.CheckedInAt = admittedAt.Format(time.RFC3339)
+	if ticket.ResalePrice > 0 {
+		errIdx := delIndex(stub, indexResale, ticket.ShowId, ticket.TicketId)
+		if errIdx != nil {
+			return shim.Error("Failed to check in ticket : " + errIdx.Error())
+		}
+		ticket.ResalePrice = 0
+	}
+	errTkt := putEntity(stub, ticket, keyTicket, ticket.TicketId)
+	if errTkt != nil {
+		return shim.Error("Failed to check in ticket : " + errTkt.Error())
+	}
+
+	ticketAsBytes, _ := json.Marshal(ticket)
+	fmt.Println("- end check_in_ticket")
+	return shim.Success(ticketAsBytes)
+}
+
+// ============================================================================================================================
+// get_show_admissions() - read the seats and tickets admitted and the no-shows of a show
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"showId":"value1"}
+// ============================================================================================================================
+func get_show_admissions(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_show_admissions")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	showId, _ := jsonValue["showId"].(string)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	show, err := getShow(stub, showId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if caller.Role != RolePlatformAdmin && show.TheatreRegNo != caller.TheatreRegNo {
+		return codedError(ErrAccessDenied, "Only "+show.TheatreRegNo+" can read the admissions of show "+showId)
+	}
+
+	cfg, err := getConfig(stub, show.TheatreRegNo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	sm, err := getSeatMap(stub, show.ShowId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var admissions ShowAdmissions
+	admissions.ShowId = show.ShowId
+	admissions.TheatreRegNo = show.TheatreRegNo
+	admissions.CheckInStatus, err = checkInStatus(show, now, cfg)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	booked := make(map[string]bool)
+	admitted := make(map[string]bool)
+	for _, seat := range sm.Seats {
+		if seat.Status != SeatSold {
+			continue
+		}
+		admissions.SeatsBooked++
+		booked[seat.TicketId] = true
+		if seat.AdmittedAt != "" {
+			admissions.SeatsAdmitted++
+			admitted[seat.TicketId] = true
+		}
+	}
+	admissions.TicketsBooked = len(booked)
+	admissions.TicketsAdmitted = len(admitted)
+	admissions.SeatsNoShow = admissions.SeatsBooked - admissions.SeatsAdmitted
+	admissionsAsBytes, _ := json.Marshal(admissions)
+
+	fmt.Println("- end get_show_admissions")
+	return shim.Success(admissionsAsBytes)
+}
+
+// ============================================================================================================================
+// get_gate_snapshot() - read the codes of the tickets which can be admitted at a theatre on a day
+//
+// The gates load the snapshot with the ticketcode package to check codes offline. Staff read the
+// snapshot of their own theatre, the platform admin names the theatre.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"theatreRegNo":"value1","showDate":"2019-12-29"}
+// ============================================================================================================================
+func get_gate_snapshot(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_gate_snapshot")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	theatreRegNo, _ := jsonValue["theatreRegNo"].(string)
+	showDate, _ := jsonValue["showDate"].(string)
+	if showDate == "" {
+		return shim.Error("showDate is required")
+	}
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	if caller.Role != RolePlatformAdmin {
+		if theatreRegNo != "" && theatreRegNo != caller.TheatreRegNo {
+			return codedError(ErrAccessDenied, "Only "+theatreRegNo+" can read its gate snapshot")
+		}
+		theatreRegNo = caller.TheatreRegNo
+	}
+	if theatreRegNo == "" {
+		return shim.Error("theatreRegNo is required")
+	}
+
+	cfg, err := getConfig(stub, theatreRegNo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	shows, err := getTheatreShows(stub, theatreRegNo, showDate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var snapshot GateSnapshot
+	snapshot.TheatreRegNo = theatreRegNo
+	snapshot.ShowDate = showDate
+	snapshot.GeneratedAt = now.Format(time.RFC3339)
+	snapshot.Tickets = []GateTicket{}
+	for _, show := range shows {
+		if show.ShowStatus == ShowCancelled {
+			continue
+		}
+		opens, closes, err := checkInWindow(show, cfg)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		tickets, err := getShowTickets(stub, show.ShowId)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		for _, ticket := range tickets {
+			var gt GateTicket
+			gt.VerifyCode = ticketCode(ticket)
+			gt.TicketId = ticket.TicketId
+			gt.ShowId = show.ShowId
+			gt.ShowStart = show.ShowStart
+			gt.ScreenNumber = show.ScreenNumber
+			gt.Seats = ticket.Seats
+			gt.CheckInOpens = opens.Format(time.RFC3339)
+			gt.CheckInCloses = closes.Format(time.RFC3339)
+			snapshot.Tickets = append(snapshot.Tickets, gt)
+		}
+	}
+	snapshotAsBytes, _ := json.Marshal(snapshot)
+
+	fmt.Println("- end get_gate_snapshot")
+	return shim.Success(snapshotAsBytes)
+}
@@ -0,0 +1,72 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Codes are checked against the ticket they are presented for, however they were typed
+func TestCheckInCode(t *testing.T) {
+	l := newTestLedger(t, 10, 10)
+	var first, second Tickets
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1"]}`), &first)
+	json.Unmarshal(l.ok(l.bob, "book_tickets", `{"showId":"S1","seats":["A2"]}`), &second)
+	l.now = time.Date(2019, 12, 29, 9, 45, 0, 0, time.UTC)
+
+	l.fail(l.cashier, "check_in_ticket", `{"ticketId":"`+second.TicketId+`","code":"`+first.VerifyCode+`"}`)
+	typed := strings.ToLower(strings.NewReplacer("-", "", "O", "0", "I", "1", "B", "8").Replace(first.VerifyCode))
+	l.ok(l.cashier, "check_in_ticket", `{"ticketId":"`+first.TicketId+`","code":"`+typed+`"}`)
+	l.fail(l.cashier, "check_in_ticket", `{"ticketId":"`+first.TicketId+`","code":"`+first.VerifyCode+`"}`)
+	l.ok(l.cashier, "check_in_ticket", `{"ticketId":"`+second.TicketId+`","code":"`+second.VerifyCode+`"}`)
+}
+
+// Tickets are checked in once, by the staff of the theatre, while check-in is open for the show
+func TestCheckInTicket(t *testing.T) {
+	l := newTestLedger(t, 10, 10)
+	var ticket, noShow Tickets
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1","A2"]}`), &ticket)
+	json.Unmarshal(l.ok(l.bob, "book_tickets", `{"showId":"S1","seats":["B1"]}`), &noShow)
+	l.fail(l.cashier, "check_in_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+
+	l.now = time.Date(2019, 12, 29, 9, 30, 0, 0, time.UTC)
+	l.fail(l.alice, "check_in_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+	json.Unmarshal(l.ok(l.cashier, "check_in_ticket", `{"ticketId":"`+ticket.TicketId+`"}`), &ticket)
+	if ticket.Status != TicketRedeemed || ticket.CheckedInAt == "" {
+		t.Fatalf("%+v", ticket)
+	}
+	l.fail(l.cashier, "check_in_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+	l.fail(l.alice, "cancel_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+
+	var admissions ShowAdmissions
+	json.Unmarshal(l.ok(l.theatreAdmin, "get_show_admissions", `{"showId":"S1"}`), &admissions)
+	if admissions.TicketsBooked != 2 || admissions.TicketsAdmitted != 1 || admissions.SeatsAdmitted != 2 || admissions.SeatsNoShow != 1 || admissions.CheckInStatus != CheckInOpen {
+		t.Fatalf("%+v", admissions)
+	}
+
+	l.now = time.Date(2019, 12, 29, 14, 0, 0, 0, time.UTC)
+	l.fail(l.cashier, "check_in_ticket", `{"ticketId":"`+noShow.TicketId+`"}`)
+	json.Unmarshal(l.ok(l.admin, "get_show_admissions", `{"showId":"S1"}`), &admissions)
+	if admissions.CheckInStatus != CheckInClosed {
+		t.Fatalf("%+v", admissions)
+	}
+	l.fail(l.alice, "get_show_admissions", `{"showId":"S1"}`)
+}
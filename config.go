@@ -0,0 +1,286 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Config Struct - business limits read by the chaincode rules
+type Config struct {
+	ObjectType             string `json:"docType"` // field defined for couchdb
+	Version                int    `json:"version"`
+	MaxShowsPerMoviePerDay int    `json:"maxShowsPerMoviePerDay"`
+	DefaultSeatRows        int    `json:"defaultSeatRows"`
+	DefaultSeatColumns     int    `json:"defaultSeatColumns"`
+	SodaPerDay             int    `json:"sodaPerDay"`
+	MaxArgumentLength      int    `json:"maxArgumentLength"`
+	DefaultRuntimeMinutes  int    `json:"defaultRuntimeMinutes"`
+	CleaningBufferMinutes  int    `json:"cleaningBufferMinutes"`
+	PreBookingDays         int    `json:"preBookingDays"`
+	RefundBatchSize        int    `json:"refundBatchSize"`
+	HoldMinutes            int    `json:"holdMinutes"`
+	ResalePriceCapPercent  int    `json:"resalePriceCapPercent"`
+	CheckInOpenMinutes     int    `json:"checkInOpenMinutes"`
+	WaitlistOfferMinutes   int    `json:"waitlistOfferMinutes"`
+	// limits of tickets, 0 is no limit
+	MaxTicketsPerTransaction  int `json:"maxTicketsPerTransaction"`
+	MaxTicketsPerBuyerPerShow int `json:"maxTicketsPerBuyerPerShow"`
+	MaxTicketsPerBuyerPerDay  int `json:"maxTicketsPerBuyerPerDay"`
+}
+
+// ConfigOverride Struct - fields of the config replaced for one theatre
+type ConfigOverride struct {
+	ObjectType   string          `json:"docType"` // field defined for couchdb
+	TheatreRegNo string          `json:"theatreRegNo"`
+	Version      int             `json:"version"`
+	Overrides    json.RawMessage `json:"overrides"`
+}
+
+// Config written by Init, same limits the chaincode always had
+func defaultConfig() Config {
+	var cfg Config
+	cfg.ObjectType = "Config"
+	cfg.Version = 1
+	cfg.MaxShowsPerMoviePerDay = 4
+	cfg.DefaultSeatRows = 10
+	cfg.DefaultSeatColumns = 10
+	cfg.SodaPerDay = 200
+	cfg.MaxArgumentLength = 32
+	cfg.DefaultRuntimeMinutes = 180
+	cfg.CleaningBufferMinutes = 15
+	cfg.PreBookingDays = 3
+	cfg.RefundBatchSize = 50
+	cfg.HoldMinutes = 10
+	cfg.ResalePriceCapPercent = 100
+	cfg.CheckInOpenMinutes = 60
+	cfg.WaitlistOfferMinutes = 30
+	cfg.MaxTicketsPerTransaction = 10
+	cfg.MaxTicketsPerBuyerPerShow = 10
+	cfg.MaxTicketsPerBuyerPerDay = 20
+	return cfg
+}
+
+// Reads the platform config, the default one when Init has not written it yet
+func getPlatformConfig(stub shim.ChaincodeStubInterface) (Config, error) {
+	cfgAsBytes, err := getEntity(stub, keyConfig)
+	if err != nil {
+		return Config{}, err
+	}
+	if cfgAsBytes == nil {
+		return defaultConfig(), nil
+	}
+	// fields added after the config was written keep their default
+	cfg := defaultConfig()
+	err = json.Unmarshal(cfgAsBytes, &cfg)
+	return cfg, err
+}
+
+// Reads the config in force for a theatre - the platform config with the theatre overrides on top.
+// An empty theatreRegNo gives the platform config.
+func getConfig(stub shim.ChaincodeStubInterface, theatreRegNo string) (Config, error) {
+	cfg, err := getPlatformConfig(stub)
+	if err != nil || theatreRegNo == "" {
+		return cfg, err
+	}
+	overrideAsBytes, err := getEntity(stub, keyConfigOverride, theatreRegNo)
+	if err != nil || overrideAsBytes == nil {
+		return cfg, err
+	}
+	var override ConfigOverride
+	err = json.Unmarshal(overrideAsBytes, &override)
+	if err != nil {
+		return cfg, err
+	}
+	version := cfg.Version
+	err = json.Unmarshal(override.Overrides, &cfg)
+	cfg.Version = version
+	return cfg, err
+}
+
+// Checks the limits of a config
+func validateConfig(cfg Config) error {
+	if cfg.MaxShowsPerMoviePerDay < 1 {
+		return errors.New("maxShowsPerMoviePerDay must be at least 1")
+	}
+	if cfg.DefaultSeatRows < 1 || cfg.DefaultSeatColumns < 1 {
+		return errors.New("defaultSeatRows and defaultSeatColumns must be at least 1")
+	}
+	if cfg.SodaPerDay < 0 {
+		return errors.New("sodaPerDay cannot be negative")
+	}
+	if cfg.MaxArgumentLength < 1 {
+		return errors.New("maxArgumentLength must be at least 1")
+	}
+	if cfg.DefaultRuntimeMinutes < 1 || cfg.DefaultRuntimeMinutes > 24*60 {
+		return errors.New("defaultRuntimeMinutes must be between 1 and 1440")
+	}
+	if cfg.CleaningBufferMinutes < 0 || cfg.CleaningBufferMinutes > 24*60 {
+		return errors.New("cleaningBufferMinutes must be between 0 and 1440")
+	}
+	if cfg.PreBookingDays < 0 {
+		return errors.New("preBookingDays cannot be negative")
+	}
+	if cfg.RefundBatchSize < 1 {
+		return errors.New("refundBatchSize must be at least 1")
+	}
+	if cfg.HoldMinutes < 1 || cfg.HoldMinutes > 24*60 {
+		return errors.New("holdMinutes must be between 1 and 1440")
+	}
+	if cfg.ResalePriceCapPercent < 1 {
+		return errors.New("resalePriceCapPercent must be at least 1")
+	}
+	if cfg.CheckInOpenMinutes < 0 || cfg.CheckInOpenMinutes > 24*60 {
+		return errors.New("checkInOpenMinutes must be between 0 and 1440")
+	}
+	if cfg.WaitlistOfferMinutes < 1 || cfg.WaitlistOfferMinutes > 24*60 {
+		return errors.New("waitlistOfferMinutes must be between 1 and 1440")
+	}
+	if cfg.MaxTicketsPerTransaction < 0 || cfg.MaxTicketsPerBuyerPerShow < 0 || cfg.MaxTicketsPerBuyerPerDay < 0 {
+		return errors.New("maxTicketsPerTransaction, maxTicketsPerBuyerPerShow and maxTicketsPerBuyerPerDay cannot be negative")
+	}
+	return nil
+}
+
+// Decodes config fields, refusing fields the config does not have
+func decodeConfigFields(fields []byte, cfg *Config) error {
+	decoder := json.NewDecoder(bytes.NewReader(fields))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(cfg)
+}
+
+// ============================================================================================================================
+// set_config() - update the platform config, or the overrides of one theatre when theatreRegNo is sent
+//
+// Only the fields sent are changed and the version of the document goes up by one.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"config":{"sodaPerDay":300}}
+//  {"theatreRegNo":"value1","config":{"maxShowsPerMoviePerDay":6}}
+// ============================================================================================================================
+func set_config(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting set_config")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	var request struct {
+		TheatreRegNo string          `json:"theatreRegNo"`
+		Config       json.RawMessage `json:"config"`
+	}
+	err := json.Unmarshal([]byte(args[0]), &request)
+	if err != nil || len(request.Config) == 0 {
+		return shim.Error("Expecting the config fields to change under \"config\"")
+	}
+
+	// the changed fields must give a valid config, on the platform config or the theatre config
+	cfg, err := getConfig(stub, request.TheatreRegNo)
+	if err != nil {
+		return shim.Error("Failed to set config : " + err.Error())
+	}
+	err = decodeConfigFields(request.Config, &cfg)
+	if err != nil {
+		return shim.Error("Invalid config : " + err.Error())
+	}
+	err = validateConfig(cfg)
+	if err != nil {
+		return shim.Error("Invalid config : " + err.Error())
+	}
+
+	if request.TheatreRegNo == "" {
+		platformCfg, err := getPlatformConfig(stub)
+		if err != nil {
+			return shim.Error("Failed to set config : " + err.Error())
+		}
+		cfg.ObjectType = "Config"
+		cfg.Version = platformCfg.Version + 1
+		errPut := putEntity(stub, cfg, keyConfig)
+		if errPut != nil {
+			return shim.Error("Failed to set config : " + errPut.Error())
+		}
+		cfgAsBytes, _ := json.Marshal(cfg)
+		fmt.Println("- end set_config")
+		return shim.Success(cfgAsBytes)
+	}
+
+	theatreAsBytes, _ := getEntity(stub, keyTheatre, request.TheatreRegNo)
+	if theatreAsBytes == nil {
+		return shim.Error("This theatre does not exists - " + request.TheatreRegNo)
+	}
+
+	// merge the fields sent into the overrides already kept for the theatre
+	var override ConfigOverride
+	overrides := make(map[string]interface{})
+	overrideAsBytes, _ := getEntity(stub, keyConfigOverride, request.TheatreRegNo)
+	if overrideAsBytes != nil {
+		json.Unmarshal(overrideAsBytes, &override)
+		json.Unmarshal(override.Overrides, &overrides)
+	}
+	json.Unmarshal(request.Config, &overrides)
+	delete(overrides, "docType")
+	delete(overrides, "version")
+	override.ObjectType = "ConfigOverride"
+	override.TheatreRegNo = request.TheatreRegNo
+	override.Version++
+	override.Overrides, _ = json.Marshal(overrides)
+
+	errPut := putEntity(stub, override, keyConfigOverride, override.TheatreRegNo)
+	if errPut != nil {
+		return shim.Error("Failed to set config : " + errPut.Error())
+	}
+
+	overrideAsBytes, _ = json.Marshal(override)
+	fmt.Println("- end set_config")
+	return shim.Success(overrideAsBytes)
+}
+
+// ============================================================================================================================
+// get_config() - read the config in force, for a theatre when theatreRegNo is sent
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"theatreRegNo":"value1"}
+// ============================================================================================================================
+func get_config(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_config")
+
+	var jsonValue map[string]interface{}
+	if len(args) > 0 {
+		json.Unmarshal([]byte(args[0]), &jsonValue)
+	}
+	theatreRegNo, _ := jsonValue["theatreRegNo"].(string)
+
+	cfg, err := getConfig(stub, theatreRegNo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	cfgAsBytes, _ := json.Marshal(cfg)
+
+	fmt.Println("- end get_config")
+	return shim.Success(cfgAsBytes)
+}
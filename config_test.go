@@ -0,0 +1,57 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Platform admins change the config, a theatre override replaces only the fields it sets
+func TestConfigOverride(t *testing.T) {
+	l := newTestLedger(t, 1, 2)
+	var cfg Config
+	json.Unmarshal(l.ok(l.alice, "get_config", `{}`), &cfg)
+	if cfg.Version != 1 || cfg.MaxShowsPerMoviePerDay != 4 || cfg.SodaPerDay != 200 {
+		t.Fatalf("%+v", cfg)
+	}
+
+	l.fail(l.theatreAdmin, "set_config", `{"config":{"sodaPerDay":1}}`)
+	response := l.fail(l.admin, "set_config", `{"config":{"sodasPerDay":1}}`)
+	if !strings.Contains(response.Message, "unknown field") {
+		t.Fatal(response.Message)
+	}
+	l.fail(l.admin, "set_config", `{"config":{"maxShowsPerMoviePerDay":0}}`)
+	json.Unmarshal(l.ok(l.admin, "set_config", `{"config":{"sodaPerDay":1,"holdMinutes":5}}`), &cfg)
+	if cfg.Version != 2 || cfg.SodaPerDay != 1 || cfg.HoldMinutes != 5 {
+		t.Fatalf("%+v", cfg)
+	}
+
+	l.ok(l.admin, "set_config", `{"theatreRegNo":"TH1","config":{"maxShowsPerMoviePerDay":1}}`)
+	l.ok(l.admin, "set_config", `{"theatreRegNo":"TH1","config":{"holdMinutes":20}}`)
+	json.Unmarshal(l.ok(l.alice, "get_config", `{"theatreRegNo":"TH1"}`), &cfg)
+	if cfg.MaxShowsPerMoviePerDay != 1 || cfg.HoldMinutes != 20 || cfg.SodaPerDay != 1 {
+		t.Fatalf("%+v", cfg)
+	}
+	json.Unmarshal(l.ok(l.alice, "get_config", `{}`), &cfg)
+	if cfg.MaxShowsPerMoviePerDay != 4 || cfg.HoldMinutes != 5 {
+		t.Fatalf("%+v", cfg)
+	}
+	l.fail(l.theatreAdmin, "add_shows", `{"showId":"S2","showTiming":"2019-12-29 06:00pm","movieId":"M1","docType":"Shows"}`)
+}
@@ -0,0 +1,350 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Hold states. An Active hold is Expired from expiresAt, nothing is written when it expires.
+const (
+	HoldActive    = "Active"
+	HoldConfirmed = "Confirmed"
+	HoldReleased  = "Released"
+	HoldExpired   = "Expired"
+)
+
+// SeatHold Struct - seats kept for a buyer while the payment is made
+type SeatHold struct {
+	ObjectType     string      `json:"docType"` // field defined for couchdb
+	HoldId         string      `json:"holdId"`
+	ShowId         string      `json:"showId"`
+	TheatreRegNo   string      `json:"theatreRegNo"`
+	Seats          []string    `json:"seats"`
+	Owner          string      `json:"owner"`
+	Status         string      `json:"status"`
+	HeldAt         string      `json:"heldAt"`
+	ExpiresAt      string      `json:"expiresAt"`
+	TotalPrice     int         `json:"totalPrice"` // price quoted when the seats were held
+	PriceBreakdown []SeatPrice `json:"priceBreakdown"`
+	TicketId       string      `json:"ticketId"` // ticket the hold was confirmed into
+	Waitlist       bool        `json:"waitlist"` // seats offered to a buyer of the waitlist
+}
+
+// Status of a hold at the time of the transaction
+func holdStatus(hold SeatHold, now time.Time) string {
+	if hold.Status != HoldActive {
+		return hold.Status
+	}
+	expiresAt, err := time.Parse(time.RFC3339, hold.ExpiresAt)
+	if err != nil || !now.Before(expiresAt) {
+		return HoldExpired
+	}
+	return HoldActive
+}
+
+// Reads a seat hold
+func getHold(stub shim.ChaincodeStubInterface, holdId string) (SeatHold, error) {
+	var hold SeatHold
+	holdAsBytes, err := getEntity(stub, keyHold, holdId)
+	if err != nil {
+		return hold, err
+	}
+	if holdAsBytes == nil {
+		return hold, errors.New("This hold does not exists - " + holdId)
+	}
+	err = json.Unmarshal(holdAsBytes, &hold)
+	return hold, err
+}
+
+// Prices the seats of a hold from the price plan of the theatre
+func quoteHold(stub shim.ChaincodeStubInterface, show Shows, mov Movies, start time.Time, hold *SeatHold, sm SeatMap) error {
+	plan, err := getPricePlan(stub, show.TheatreRegNo)
+	if err != nil {
+		return err
+	}
+	hold.TotalPrice = 0
+	hold.PriceBreakdown = nil
+	for _, seat := range sm.Seats {
+		if seat.HoldId == hold.HoldId {
+			price := priceSeat(plan, start, seat.SeatId, seat.Category, mov.Format)
+			hold.PriceBreakdown = append(hold.PriceBreakdown, price)
+			hold.TotalPrice += price.Price
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// hold_seats() - hold seats of a show for holdMinutes of the config, confirm_hold then sells them
+//
+// Held seats are not counted as booked, they are only taken until the hold expires.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"showId":"value1","seats":["A1","A2"]}
+// ============================================================================================================================
+func hold_seats(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting hold_seats")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	var hold SeatHold
+	json.Unmarshal([]byte(args[0]), &hold)
+	shAsBytes, _ := getEntity(stub, keyShow, hold.ShowId)
+	if shAsBytes == nil {
+		return shim.Error("This show does not exists - " + hold.ShowId)
+	}
+	show := Shows{}
+	json.Unmarshal(shAsBytes, &show)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	if caller.Role == RoleCashier && caller.TheatreRegNo != show.TheatreRegNo {
+		return codedError(ErrAccessDenied, "Cashiers can only hold seats for shows of "+caller.TheatreRegNo)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to hold seats : " + err.Error())
+	}
+	mov, start, err := checkBookable(stub, show, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	cfg, err := getConfig(stub, show.TheatreRegNo)
+	if err != nil {
+		return shim.Error("Failed to hold seats : " + err.Error())
+	}
+	// limits are checked again when the hold is confirmed, only then the tickets are counted
+	err = checkTransactionLimit(cfg, len(hold.Seats))
+	if err == nil && caller.Role != RoleCashier {
+		_, _, err = checkBuyerLimits(stub, cfg, caller.OwnerId(), show, len(hold.Seats))
+	}
+	if err != nil {
+		return purchaseError("Failed to hold seats : ", err)
+	}
+	hold.ObjectType = "SeatHold"
+	hold.HoldId = "H" + txScopedId(stub)
+	hold.TheatreRegNo = show.TheatreRegNo
+	hold.Owner = caller.OwnerId()
+	hold.Status = HoldActive
+	hold.HeldAt = now.Format(time.RFC3339)
+	expiresAt := now.Add(time.Duration(cfg.HoldMinutes) * time.Minute)
+	hold.ExpiresAt = expiresAt.Format(time.RFC3339)
+	hold.TicketId = ""
+	hold.Waitlist = false // only offers of the waitlist hold seats for its buyers
+
+	sm, err := getSeats(stub, show.ShowId, hold.Seats)
+	if err != nil {
+		return shim.Error("Failed to hold seats : " + err.Error())
+	}
+	err = checkLapsedOffers(stub, show, sm, now)
+	if err == nil {
+		err = holdSeats(&sm, hold.Seats, hold.HoldId, expiresAt, now)
+	}
+	if err != nil {
+		return shim.Error("Failed to hold seats : " + err.Error())
+	}
+
+	err = quoteHold(stub, show, mov, start, &hold, sm)
+	if err != nil {
+		return shim.Error("Failed to hold seats : " + err.Error())
+	}
+
+	errHold := putEntity(stub, hold, keyHold, hold.HoldId)
+	if errHold != nil {
+		return shim.Error("Failed to hold seats : " + errHold.Error())
+	}
+	errSm := putSeats(stub, sm)
+	if errSm != nil {
+		return shim.Error("Failed to hold seats : " + errSm.Error())
+	}
+
+	holdAsBytes, _ := json.Marshal(hold)
+	fmt.Println("- end hold_seats")
+	return shim.Success(holdAsBytes)
+}
+
+// ============================================================================================================================
+// confirm_hold() - sell the seats of an active hold, gives the ticket booked
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"holdId":"value1"}
+// ============================================================================================================================
+func confirm_hold(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting confirm_hold")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	holdId, _ := jsonValue["holdId"].(string)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	hold, err := getHold(stub, holdId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if hold.Owner != caller.OwnerId() {
+		return codedError(ErrAccessDenied, "Only the owner of the hold can confirm it - "+holdId)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to confirm hold : " + err.Error())
+	}
+	if status := holdStatus(hold, now); status != HoldActive {
+		return shim.Error("This hold is " + status + " - " + holdId)
+	}
+
+	shAsBytes, _ := getEntity(stub, keyShow, hold.ShowId)
+	if shAsBytes == nil {
+		return shim.Error("This show does not exists - " + hold.ShowId)
+	}
+	show := Shows{}
+	json.Unmarshal(shAsBytes, &show)
+	mov, start, err := checkBookable(stub, show, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	sm, err := getSeats(stub, show.ShowId, hold.Seats)
+	if err != nil {
+		return shim.Error("Failed to confirm hold : " + err.Error())
+	}
+	if caller.Role != RoleCashier {
+		cfg, err := getConfig(stub, show.TheatreRegNo)
+		if err == nil {
+			err = addPurchase(stub, cfg, caller.OwnerId(), show, len(hold.Seats))
+		}
+		if err != nil {
+			return purchaseError("Failed to confirm hold : ", err)
+		}
+	}
+
+	var ticket Tickets
+	ticket.Seats = hold.Seats
+	ticket.Owner = hold.Owner
+	countedFor := ""
+	if caller.Role != RoleCashier {
+		countedFor = caller.OwnerId()
+	}
+	ticket, err = issueTicket(stub, show, mov, ticket, countedFor, sm, hold, now, start)
+	if err != nil {
+		return shim.Error("Failed to confirm hold : " + err.Error())
+	}
+
+	hold.Status = HoldConfirmed
+	hold.TicketId = ticket.TicketId
+	errHold := putEntity(stub, hold, keyHold, hold.HoldId)
+	if errHold != nil {
+		return shim.Error("Failed to confirm hold : " + errHold.Error())
+	}
+
+	ticketAsBytes, _ := json.Marshal(ticket)
+	fmt.Println("- end confirm_hold")
+	return shim.Success(ticketAsBytes)
+}
+
+// ============================================================================================================================
+// release_hold() - give back the seats of an active hold before it expires
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"holdId":"value1"}
+// ============================================================================================================================
+func release_hold(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting release_hold")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	holdId, _ := jsonValue["holdId"].(string)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	hold, err := getHold(stub, holdId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if hold.Owner != caller.OwnerId() {
+		return codedError(ErrAccessDenied, "Only the owner of the hold can release it - "+holdId)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to release hold : " + err.Error())
+	}
+	if status := holdStatus(hold, now); status != HoldActive {
+		return shim.Error("This hold is " + status + " - " + holdId)
+	}
+
+	sm, err := getSeats(stub, hold.ShowId, hold.Seats)
+	if err != nil {
+		return shim.Error("Failed to release hold : " + err.Error())
+	}
+	releaseHeldSeats(&sm, hold.HoldId)
+
+	hold.Status = HoldReleased
+	errHold := putEntity(stub, hold, keyHold, hold.HoldId)
+	if errHold != nil {
+		return shim.Error("Failed to release hold : " + errHold.Error())
+	}
+	errSm := putSeats(stub, sm)
+	if errSm != nil {
+		return shim.Error("Failed to release hold : " + errSm.Error())
+	}
+
+	// seats given back go to the waitlist of the show first
+	show, err := getShow(stub, hold.ShowId)
+	if err != nil {
+		return shim.Error("Failed to release hold : " + err.Error())
+	}
+	err = offerToWaitlist(stub, show, sm, now)
+	if err != nil {
+		return shim.Error("Failed to release hold : " + err.Error())
+	}
+
+	holdAsBytes, _ := json.Marshal(hold)
+	fmt.Println("- end release_hold")
+	return shim.Success(holdAsBytes)
+}
@@ -0,0 +1,86 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// A hold is confirmed at the price it quoted, even when the price plan changed meanwhile
+func TestConfirmHoldKeepsTheQuotedPrice(t *testing.T) {
+	l := newTestLedger(t, 10, 10)
+	l.ok(l.theatreAdmin, "set_price_plan", `{"basePrice":150}`)
+
+	var hold SeatHold
+	json.Unmarshal(l.ok(l.alice, "hold_seats", `{"showId":"S1","seats":["A1","A2"]}`), &hold)
+	if hold.TotalPrice != 300 {
+		t.Fatalf("%+v", hold)
+	}
+	l.ok(l.theatreAdmin, "set_price_plan", `{"basePrice":200}`)
+
+	var ticket Tickets
+	json.Unmarshal(l.ok(l.alice, "confirm_hold", `{"holdId":"`+hold.HoldId+`"}`), &ticket)
+	if ticket.TotalPrice != hold.TotalPrice || len(ticket.PriceBreakdown) != 2 || ticket.PriceBreakdown[0].Price != 150 {
+		t.Fatalf("%+v", ticket)
+	}
+
+	// seats booked without a hold take the plan in force
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A3"],"totalPrice":1,"priceBreakdown":[{"seatId":"A3","price":1}]}`), &ticket)
+	if ticket.TotalPrice != 200 || len(ticket.PriceBreakdown) != 1 {
+		t.Fatalf("%+v", ticket)
+	}
+}
+
+// Held seats are kept for the buyer until the hold is confirmed, released or expires
+func TestHoldSeats(t *testing.T) {
+	l := newTestLedger(t, 3, 3)
+	var hold SeatHold
+	json.Unmarshal(l.ok(l.alice, "hold_seats", `{"showId":"S1","seats":["A1","A2"]}`), &hold)
+	if hold.Status != HoldActive || hold.ExpiresAt != "2019-12-20T09:10:00Z" {
+		t.Fatalf("%+v", hold)
+	}
+	l.fail(l.bob, "book_tickets", `{"showId":"S1","seats":["A1"]}`)
+	l.fail(l.bob, "hold_seats", `{"showId":"S1","seats":["A2"]}`)
+	l.fail(l.bob, "confirm_hold", `{"holdId":"`+hold.HoldId+`"}`)
+	var ticket Tickets
+	json.Unmarshal(l.ok(l.alice, "confirm_hold", `{"holdId":"`+hold.HoldId+`"}`), &ticket)
+	if ticket.NumberOfTickets != 2 || ticket.TotalPrice != hold.TotalPrice {
+		t.Fatalf("%+v", ticket)
+	}
+	l.fail(l.alice, "confirm_hold", `{"holdId":"`+hold.HoldId+`"}`)
+
+	json.Unmarshal(l.ok(l.alice, "hold_seats", `{"showId":"S1","seats":["B1"]}`), &hold)
+	l.ok(l.alice, "release_hold", `{"holdId":"`+hold.HoldId+`"}`)
+	l.fail(l.alice, "release_hold", `{"holdId":"`+hold.HoldId+`"}`)
+	l.ok(l.bob, "hold_seats", `{"showId":"S1","seats":["B1"]}`)
+
+	// an expired hold needs no transaction to free its seats
+	json.Unmarshal(l.ok(l.alice, "hold_seats", `{"showId":"S1","seats":["C1"]}`), &hold)
+	l.now = l.now.Add(11 * time.Minute)
+	var sm SeatMap
+	json.Unmarshal(l.ok(l.alice, "get_seat_map", `{"showId":"S1"}`), &sm)
+	for _, seat := range sm.Seats {
+		if seat.SeatId == "C1" && seat.Status != SeatFree {
+			t.Fatalf("%+v", seat)
+		}
+	}
+	l.fail(l.alice, "confirm_hold", `{"holdId":"`+hold.HoldId+`"}`)
+	l.ok(l.bob, "book_tickets", `{"showId":"S1","seats":["C1"]}`)
+}
@@ -0,0 +1,267 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Job types and states
+const (
+	JobShowRefund = "ShowRefund"
+
+	JobPending    = "Pending"
+	JobInProgress = "InProgress"
+	JobCompleted  = "Completed"
+)
+
+// Chaincode event sent for each batch of refunds
+const (
+	EventTicketsRefunded = "TicketsRefunded"
+)
+
+// BatchJob Struct - work spread over several transactions. Each batch carries on from the
+// bookmark left by the one before, so a failed batch can simply be sent again.
+type BatchJob struct {
+	ObjectType      string `json:"docType"` // field defined for couchdb
+	JobId           string `json:"jobId"`
+	JobType         string `json:"jobType"`
+	TheatreRegNo    string `json:"theatreRegNo"`
+	ShowId          string `json:"showId"`
+	Reason          string `json:"reason"`
+	Status          string `json:"status"`
+	Bookmark        string `json:"bookmark"` // last ticket processed, to follow the progress of the job
+	Batches         int    `json:"batches"`
+	TicketsRefunded int    `json:"ticketsRefunded"`
+	AmountRefunded  int    `json:"amountRefunded"`
+	CreatedAt       string `json:"createdAt"`
+	UpdatedAt       string `json:"updatedAt"`
+	CompletedAt     string `json:"completedAt"`
+}
+
+// RefundBatch Struct - result of one batch, also the payload of the TicketsRefunded event
+type RefundBatch struct {
+	JobId   string         `json:"jobId"`
+	ShowId  string         `json:"showId"`
+	Status  string         `json:"status"`
+	Tickets []TicketHolder `json:"tickets"`
+}
+
+// Id of the refund job of a cancelled show
+func showRefundJobId(showId string) string {
+	return "RJ" + showId
+}
+
+// Creates the job refunding every ticket of a cancelled show
+func newShowRefundJob(stub shim.ChaincodeStubInterface, show Shows, reason string, now time.Time) (BatchJob, error) {
+	var job BatchJob
+	job.ObjectType = "BatchJob"
+	job.JobId = showRefundJobId(show.ShowId)
+	job.JobType = JobShowRefund
+	job.TheatreRegNo = show.TheatreRegNo
+	job.ShowId = show.ShowId
+	job.Reason = reason
+	job.Status = JobPending
+	job.CreatedAt = now.Format(time.RFC3339)
+	job.UpdatedAt = job.CreatedAt
+	err := putEntity(stub, job, keyJob, job.JobId)
+	return job, err
+}
+
+// Reads a batch job
+func getJob(stub shim.ChaincodeStubInterface, jobId string) (BatchJob, error) {
+	var job BatchJob
+	jobAsBytes, err := getEntity(stub, keyJob, jobId)
+	if err != nil {
+		return job, err
+	}
+	if jobAsBytes == nil {
+		return job, errors.New("This job does not exists - " + jobId)
+	}
+	err = json.Unmarshal(jobAsBytes, &job)
+	return job, err
+}
+
+// Refunds the next batchSize tickets of the show. The show~ticket entry of each ticket processed is
+// deleted, so the index only keeps the tickets left and every batch reads batchSize entries from its
+// start. Paginated queries cannot be used by transactions which write.
+func refundShowBatch(stub shim.ChaincodeStubInterface, job *BatchJob, batchSize int, now time.Time) (RefundBatch, error) {
+	var batch RefundBatch
+	batch.JobId = job.JobId
+	batch.ShowId = job.ShowId
+
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(indexShowTicket, []string{job.ShowId})
+	if err != nil {
+		return batch, err
+	}
+	defer resultsIterator.Close()
+
+	processed := 0
+	for resultsIterator.HasNext() && processed < batchSize {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return batch, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return batch, err
+		}
+		ticketId := keyParts[1]
+		processed++
+		job.Bookmark = ticketId
+		err = delIndex(stub, indexShowTicket, job.ShowId, ticketId)
+		if err != nil {
+			return batch, err
+		}
+
+		tktAsBytes, _ := getEntity(stub, keyTicket, ticketId)
+		if tktAsBytes == nil {
+			continue
+		}
+		ticket := Tickets{}
+		json.Unmarshal(tktAsBytes, &ticket)
+		if ticket.Status != TicketBooked {
+			continue
+		}
+		refund, err := refundTicket(stub, ticket, 100, now, "Show cancelled: "+job.Reason)
+		if err != nil {
+			return batch, err
+		}
+		job.TicketsRefunded++
+		job.AmountRefunded += refund.RefundAmount
+		batch.Tickets = append(batch.Tickets, TicketHolder{TicketId: ticket.TicketId, Owner: ticket.Owner, RefundAmount: refund.RefundAmount})
+	}
+
+	job.Batches++
+	job.UpdatedAt = now.Format(time.RFC3339)
+	job.Status = JobInProgress
+	if !resultsIterator.HasNext() {
+		job.Status = JobCompleted
+		job.CompletedAt = job.UpdatedAt
+	}
+	batch.Status = job.Status
+	return batch, nil
+}
+
+// ============================================================================================================================
+// process_refund_batch() - refund the next batch of tickets of a cancelled show
+//
+// Call again until the returned status is Completed. batchSize is capped by refundBatchSize of the config.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"jobId":"value1","batchSize":50}
+// ============================================================================================================================
+func process_refund_batch(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting process_refund_batch")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	var request struct {
+		JobId     string `json:"jobId"`
+		BatchSize int    `json:"batchSize"`
+	}
+	json.Unmarshal([]byte(args[0]), &request)
+
+	job, err := getJob(stub, request.JobId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	if caller.Role == RoleTheatreAdmin && caller.TheatreRegNo != job.TheatreRegNo {
+		return codedError(ErrAccessDenied, "Only "+job.TheatreRegNo+" can run job "+job.JobId)
+	}
+	if job.JobType != JobShowRefund {
+		return shim.Error("Job " + job.JobId + " is not a refund job")
+	}
+	if job.Status == JobCompleted {
+		return shim.Error("This job is already completed - " + job.JobId)
+	}
+
+	cfg, err := getConfig(stub, job.TheatreRegNo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	batchSize := request.BatchSize
+	if batchSize <= 0 || batchSize > cfg.RefundBatchSize {
+		batchSize = cfg.RefundBatchSize
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	batch, err := refundShowBatch(stub, &job, batchSize, now)
+	if err != nil {
+		return shim.Error("Failed to process refund batch : " + err.Error())
+	}
+	errJob := putEntity(stub, job, keyJob, job.JobId)
+	if errJob != nil {
+		return shim.Error("Failed to process refund batch : " + errJob.Error())
+	}
+
+	batchAsBytes, _ := json.Marshal(batch)
+	err = stub.SetEvent(EventTicketsRefunded, batchAsBytes)
+	if err != nil {
+		return shim.Error("Failed to process refund batch : " + err.Error())
+	}
+
+	fmt.Println("- end process_refund_batch")
+	return shim.Success(batchAsBytes)
+}
+
+// ============================================================================================================================
+// get_job() - read the progress of a batch job
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"jobId":"value1"}
+// ============================================================================================================================
+func get_job(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_job")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	jobId, _ := jsonValue["jobId"].(string)
+
+	job, err := getJob(stub, jobId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	jobAsBytes, _ := json.Marshal(job)
+
+	fmt.Println("- end get_job")
+	return shim.Success(jobAsBytes)
+}
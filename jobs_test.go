@@ -0,0 +1,56 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Each batch reads only the index entries of the tickets it refunds
+func TestRefundBatchReadsOnlyItsTickets(t *testing.T) {
+	l := newTestLedger(t, 10, 10)
+	for _, seat := range []string{"A1", "A2", "A3", "A4", "A5"} {
+		l.ok(l.cashier, "book_tickets", `{"showId":"S1","seats":["`+seat+`"]}`)
+	}
+	var change ShowChange
+	json.Unmarshal(l.ok(l.theatreAdmin, "cancel_show", `{"showId":"S1","reason":"flood"}`), &change)
+
+	var batch RefundBatch
+	for batches := 1; batch.Status != JobCompleted; batches++ {
+		if batches > 3 {
+			t.Fatal("5 tickets not refunded by 3 batches of 2")
+		}
+		tx := l.simulate(l.theatreAdmin, l.now, "process_refund_batch", `{"jobId":"`+change.RefundJobId+`","batchSize":2}`)
+		if invalid := l.commit([]*benchTx{tx}); len(invalid) > 0 {
+			t.Fatal(tx.response.Message)
+		}
+		for _, r := range tx.ranges {
+			if len(r.keys) > 3 {
+				t.Fatalf("batch %d read %d index entries for 2 tickets", batches, len(r.keys))
+			}
+		}
+		json.Unmarshal(tx.response.Payload, &batch)
+	}
+
+	var job BatchJob
+	json.Unmarshal(l.ok(l.admin, "get_job", `{"jobId":"`+change.RefundJobId+`"}`), &job)
+	if job.TicketsRefunded != 5 || job.Batches != 3 {
+		t.Fatalf("%+v", job)
+	}
+}
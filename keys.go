@@ -0,0 +1,443 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Object types used as prefix of the composite keys of each entity
+const (
+	keyTheatre        = "Theatre"        // theatreRegNo
+	keyMovie          = "Movies"         // theatreRegNo, movieId
+	keyShow           = "Shows"          // showId
+	keyTicket         = "Tickets"        // ticketId
+	keyAccessories    = "Accessories"    // asset, theatreRegNo, forDate
+	keySeatMap        = "SeatMap"        // showId, layout of the seats
+	keySeat           = "Seat"           // showId, seatId
+	keyRefund         = "Refunds"        // ticketId
+	keyRefundPolicy   = "RefundPolicy"   // no attributes
+	keyTransaction    = "Transaction"    // transactionGroupId, generic inserts
+	keyPlatform       = "Platform"       // no attributes
+	keyPricePlan      = "PricePlan"      // theatreRegNo
+	keyConfig         = "Config"         // no attributes
+	keyConfigOverride = "ConfigOverride" // theatreRegNo
+	keyScreen         = "Screen"         // theatreRegNo, screenNumber
+	keyJob            = "BatchJob"       // jobId
+	keyHold           = "SeatHold"       // holdId
+	keyPurchaseCount  = "PurchaseCount"  // owner, Show or Day, showId or showDate
+	keyWaitlist       = "Waitlist"       // showId, owner
+)
+
+// Index keys, they only point at an entity and hold no value of their own
+const (
+	indexTheatreShow = "theatre~date~show" // theatreRegNo, showDate, showId
+	indexShowTicket  = "show~ticket"       // showId, ticketId
+	indexOwnerTicket = "owner~ticket"      // owner, showStart (UTC), ticketId
+	indexRequest     = "request~ticket"    // owner, requestId, request hash, ticketId
+	indexResale      = "resale~ticket"     // showId, ticketId
+	indexWaitlist    = "waitlist~show"     // showId, joinedAt (UTC, fixed width), owner
+)
+
+// Value written under index keys, an empty value would delete the key
+var indexValue = []byte{0x00}
+
+// Longest key attribute accepted by read, enough for the ids minted and the 64 hex owner ids
+const maxKeyAttributeLength = 128
+
+// Reads an entity by its object type and key attributes
+func getEntity(stub shim.ChaincodeStubInterface, objectType string, attributes ...string) ([]byte, error) {
+	key, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return stub.GetState(key)
+}
+
+// Writes an entity under its object type and key attributes
+func putEntity(stub shim.ChaincodeStubInterface, value interface{}, objectType string, attributes ...string) error {
+	key, err := stub.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return err
+	}
+	valueAsBytes, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, valueAsBytes)
+}
+
+// Writes an index key
+func putIndex(stub shim.ChaincodeStubInterface, index string, attributes ...string) error {
+	key, err := stub.CreateCompositeKey(index, attributes)
+	if err != nil {
+		return err
+	}
+	return stub.PutState(key, indexValue)
+}
+
+// Deletes an index key
+func delIndex(stub shim.ChaincodeStubInterface, index string, attributes ...string) error {
+	key, err := stub.CreateCompositeKey(index, attributes)
+	if err != nil {
+		return err
+	}
+	return stub.DelState(key)
+}
+
+// Reads the attributes of every index key matching the leading attributes given
+func getIndex(stub shim.ChaincodeStubInterface, index string, attributes ...string) ([][]string, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(index, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var entries [][]string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, keyParts)
+	}
+	return entries, nil
+}
+
+// Reads every entity whose key starts with the attributes given
+func getEntitiesByPartialKey(stub shim.ChaincodeStubInterface, objectType string, attributes ...string) ([][]byte, error) {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	defer resultsIterator.Close()
+
+	var values [][]byte
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, queryResponse.Value)
+	}
+	return values, nil
+}
+
+// Reads every show of a theatre, or of one day of a theatre when showDate is set
+func getTheatreShows(stub shim.ChaincodeStubInterface, theatreRegNo string, showDate string) ([]Shows, error) {
+	attributes := []string{theatreRegNo}
+	if showDate != "" {
+		attributes = append(attributes, showDate)
+	}
+	entries, err := getIndex(stub, indexTheatreShow, attributes...)
+	if err != nil {
+		return nil, err
+	}
+	var shows []Shows
+	for _, entry := range entries {
+		showAsBytes, err := getEntity(stub, keyShow, entry[2])
+		if err != nil {
+			return nil, err
+		}
+		if showAsBytes == nil {
+			continue
+		}
+		show := Shows{}
+		json.Unmarshal(showAsBytes, &show)
+		shows = append(shows, show)
+	}
+	return shows, nil
+}
+
+// Joins JSON documents into a JSON array
+func jsonArray(values [][]byte) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("[")
+	buffer.Write(bytes.Join(values, []byte(",")))
+	buffer.WriteString("]")
+	return buffer.Bytes()
+}
+
+// ============================================================================================================================
+// get_theatre_shows() - read all shows of a theatre, optionally for one date
+//
+// Shows Off GetStateByPartialCompositeKey() - reading entities by the leading attributes of their key
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"theatreRegNo":"value1","showDate":"2019-12-29"}
+// ============================================================================================================================
+func get_theatre_shows(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_theatre_shows")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	theatreRegNo, _ := jsonValue["theatreRegNo"].(string)
+	showDate, _ := jsonValue["showDate"].(string)
+
+	shows, err := getTheatreShows(stub, theatreRegNo, showDate)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if shows == nil {
+		shows = []Shows{}
+	}
+
+	// status as of this transaction, HouseFull and Completed follow from seats and time
+	cfg, err := getConfig(stub, theatreRegNo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for i := range shows {
+		err = countSeats(stub, &shows[i])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		shows[i].ShowStatus = showStatus(shows[i], now, cfg)
+	}
+	showsAsBytes, _ := json.Marshal(shows)
+
+	fmt.Println("- end get_theatre_shows")
+	return shim.Success(showsAsBytes)
+}
+
+// ============================================================================================================================
+// get_theatre_movies() - read all movies of a theatre
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"theatreRegNo":"value1"}
+// ============================================================================================================================
+func get_theatre_movies(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_theatre_movies")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	theatreRegNo, _ := jsonValue["theatreRegNo"].(string)
+
+	moviesAsBytes, err := getEntitiesByPartialKey(stub, keyMovie, theatreRegNo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// status as of this transaction, refresh_movie_status records it on the ledger
+	theatre := Theatre{}
+	theatreAsBytes, _ := getEntity(stub, keyTheatre, theatreRegNo)
+	json.Unmarshal(theatreAsBytes, &theatre)
+	loc, err := theatreLocation(theatre.TimeZone)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	movies := []Movies{}
+	for _, movieAsBytes := range moviesAsBytes {
+		mov := Movies{}
+		json.Unmarshal(movieAsBytes, &mov)
+		if status, err := movieStatus(mov, now, loc); err == nil {
+			mov.Status = status
+		}
+		movies = append(movies, mov)
+	}
+	resultAsBytes, _ := json.Marshal(movies)
+
+	fmt.Println("- end get_theatre_movies")
+	return shim.Success(resultAsBytes)
+}
+
+// MigrationResult Struct
+type MigrationResult struct {
+	Migrated   int      `json:"migrated"`
+	Skipped    int      `json:"skipped"`
+	NextKey    string   `json:"nextKey"`
+	Unassigned []string `json:"unassigned"` // soda quotas left on their flat key, no theatre was given for them
+}
+
+// ============================================================================================================================
+// migrate_keys() - move entities written under flat keys to namespaced composite keys
+//
+// Scans at most "limit" flat keys starting at "startKey". Each known entity is written under its
+// composite key, its index keys are added and the flat key is deleted. Keys that are not entities
+// (selftest, projects_ui) are left alone. Call again with the returned nextKey
+// until it comes back empty.
+//
+// Soda quotas were kept for all theatres together before, they are given to sodaTheatreRegNo. Without
+// it they are left on their flat key and listed under unassigned, to migrate once a theatre is chosen.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"startKey":"","limit":100,"sodaTheatreRegNo":"value1"}
+// ============================================================================================================================
+func migrate_keys(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting migrate_keys")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var request struct {
+		StartKey         string `json:"startKey"`
+		Limit            int    `json:"limit"`
+		SodaTheatreRegNo string `json:"sodaTheatreRegNo"`
+	}
+	json.Unmarshal([]byte(args[0]), &request)
+	if request.Limit <= 0 {
+		request.Limit = 100
+	}
+
+	resultsIterator, err := stub.GetStateByRange(request.StartKey, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	var result MigrationResult
+	scanned := 0
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if scanned == request.Limit {
+			result.NextKey = queryResponse.Key
+			break
+		}
+		scanned++
+
+		migrated, err := migrateKey(stub, queryResponse.Key, queryResponse.Value, request.SodaTheatreRegNo)
+		if err != nil {
+			return shim.Error("Failed to migrate key " + queryResponse.Key + " : " + err.Error())
+		}
+		if migrated {
+			result.Migrated++
+		} else {
+			result.Skipped++
+			if unassignedSoda(queryResponse.Value) {
+				result.Unassigned = append(result.Unassigned, queryResponse.Key)
+			}
+		}
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+	fmt.Println("- end migrate_keys, migrated " + strconv.Itoa(result.Migrated))
+	return shim.Success(resultAsBytes)
+}
+
+// Tells if a flat key holds a soda quota written before quotas were kept per theatre
+func unassignedSoda(value []byte) bool {
+	var doc map[string]interface{}
+	if json.Unmarshal(value, &doc) != nil {
+		return false
+	}
+	theatreRegNo, _ := doc["theatreRegNo"].(string)
+	return doc["docType"] == "Accessories" && theatreRegNo == ""
+}
+
+// Moves one flat key to its composite key, returns false when the key is not a known entity or
+// is a soda quota without a theatre and no sodaTheatreRegNo was given for it
+func migrateKey(stub shim.ChaincodeStubInterface, key string, value []byte, sodaTheatreRegNo string) (bool, error) {
+	var doc map[string]interface{}
+	if json.Unmarshal(value, &doc) != nil {
+		return false, nil
+	}
+	docType, _ := doc["docType"].(string)
+	theatreRegNo, _ := doc["theatreRegNo"].(string)
+	movieId, _ := doc["movieId"].(string)
+	showId, _ := doc["showId"].(string)
+	ticketId, _ := doc["ticketId"].(string)
+	transactionGroupId, _ := doc["transactionGroupId"].(string)
+
+	var err error
+	switch {
+	case key == transactionGroupId:
+		err = putEntity(stub, doc, keyTransaction, transactionGroupId)
+	case key == keyRefundPolicy:
+		err = putEntity(stub, doc, keyRefundPolicy)
+	case docType == "Refunds" && ticketId != "":
+		err = putEntity(stub, doc, keyRefund, ticketId)
+	case docType == "SeatMap" && showId != "":
+		err = putEntity(stub, doc, keySeatMap, showId)
+	case docType == "Accessories":
+		if theatreRegNo == "" {
+			if sodaTheatreRegNo == "" {
+				return false, nil // no reader would find it under an empty theatre
+			}
+			theatreRegNo = sodaTheatreRegNo
+			doc["theatreRegNo"] = theatreRegNo
+		}
+		asset, _ := doc["asset"].(string)
+		forDate, _ := doc["forDate"].(string)
+		err = putEntity(stub, doc, keyAccessories, asset, theatreRegNo, forDate)
+	case key == ticketId:
+		err = putEntity(stub, doc, keyTicket, ticketId)
+		if err == nil {
+			err = putIndex(stub, indexShowTicket, showId, ticketId)
+		}
+	case key == showId:
+		showDate, _ := doc["showDate"].(string)
+		err = putEntity(stub, doc, keyShow, showId)
+		if err == nil {
+			err = putIndex(stub, indexTheatreShow, theatreRegNo, showDate, showId)
+		}
+	case key == movieId && theatreRegNo != "":
+		err = putEntity(stub, doc, keyMovie, theatreRegNo, movieId)
+	case key == theatreRegNo:
+		err = putEntity(stub, doc, keyTheatre, theatreRegNo)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, stub.DelState(key)
+}
+
+// Ledger key from read arguments - a single flat key, or an object type followed by key attributes
+func ledgerKey(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return stub.CreateCompositeKey(args[0], args[1:])
+}
@@ -0,0 +1,76 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Entities under flat keys are moved to their composite keys a page at a time
+func TestMigrateKeys(t *testing.T) {
+	l := newTestLedger(t, 1, 1)
+	flat := map[string]string{
+		"TH9":        `{"docType":"Theatre","theatreRegNo":"TH9","theatreName":"Old","numberOfScreens":1}`,
+		"M9":         `{"docType":"Movies","movieId":"M9","theatreRegNo":"TH9","movieName":"Old film"}`,
+		"S9":         `{"docType":"Shows","showId":"S9","movieId":"M9","theatreRegNo":"TH9","showDate":"2019-12-29","showTiming":"2019-12-29 10:00am","totalSeat":100,"availableSeat":100}`,
+		"T9":         `{"docType":"Tickets","ticketId":"T9","showId":"S9"}`,
+		"2019-12-29": `{"docType":"Accessories","asset":"Soda","forDate":"2019-12-29","availableQty":150}`,
+		"selftest":   `1`,
+	}
+	for key, value := range flat {
+		l.state[key] = []byte(value)
+	}
+
+	var result MigrationResult
+	json.Unmarshal(l.ok(l.admin, "migrate_keys", `{"limit":3}`), &result)
+	if result.Migrated+result.Skipped != 3 || result.NextKey == "" {
+		t.Fatalf("%+v", result)
+	}
+	json.Unmarshal(l.ok(l.admin, "migrate_keys", `{"startKey":"`+result.NextKey+`","limit":100}`), &result)
+	if result.NextKey != "" {
+		t.Fatalf("%+v", result)
+	}
+
+	// the soda quota is kept until a theatre is given for it
+	json.Unmarshal(l.ok(l.admin, "migrate_keys", `{}`), &result)
+	if result.Migrated != 0 || len(result.Unassigned) != 1 || result.Unassigned[0] != "2019-12-29" {
+		t.Fatalf("%+v", result)
+	}
+	json.Unmarshal(l.ok(l.admin, "migrate_keys", `{"sodaTheatreRegNo":"TH9"}`), &result)
+	if result.Migrated != 1 || len(result.Unassigned) != 0 {
+		t.Fatalf("%+v", result)
+	}
+	for key := range flat {
+		if l.state[key] != nil && key != "selftest" {
+			t.Fatalf("flat key %s left", key)
+		}
+	}
+
+	var shows []Shows
+	json.Unmarshal(l.ok(l.alice, "get_theatre_shows", `{"theatreRegNo":"TH9","showDate":"2019-12-29"}`), &shows)
+	if len(shows) != 1 || shows[0].ShowId != "S9" {
+		t.Fatalf("%+v", shows)
+	}
+	var soda Accessories
+	json.Unmarshal(l.ok(l.admin, "read", "entity", "Accessories", "Soda", "TH9", "2019-12-29"), &soda)
+	if soda.AvailableQty != 150 {
+		t.Fatalf("%+v", soda)
+	}
+	l.ok(l.admin, "read", "entity", "Tickets", "T9")
+}
@@ -0,0 +1,78 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// testLedger - mock ledger of the benchmark with the clients used by the tests. Every call is a
+// transaction of its own, validated and committed like on the peer.
+type testLedger struct {
+	*benchLedger
+	t            *testing.T
+	now          time.Time
+	admin        []byte
+	theatreAdmin []byte
+	cashier      []byte
+	alice        []byte
+	bob          []byte
+}
+
+// Ledger with theatre TH1 of one screen of rows x columns seats, movie M1 and show S1 on
+// 2019-12-29 10:00am, ten days before the show
+func newTestLedger(t *testing.T, rows int, columns int) *testLedger {
+	theatre := `{"theatreRegNo":"TH1","theatreName":"Test","numberOfScreens":1,"docType":"Theatre",` +
+		`"screenLayouts":[{"screenNumber":1,"rows":` + strconv.Itoa(rows) + `,"columns":` + strconv.Itoa(columns) + `}]}`
+	l := &testLedger{benchLedger: newBenchLedger(), t: t, now: time.Date(2019, 12, 20, 9, 0, 0, 0, time.UTC)}
+	l.admin = benchIdentity("Org1MSP", "admin", map[string]string{"role": "platformAdmin"})
+	l.theatreAdmin = benchIdentity("Org1MSP", "TH1", map[string]string{"role": "theatreAdmin", "theatreRegNo": "TH1"})
+	l.cashier = benchIdentity("Org1MSP", "cashier", map[string]string{"role": "cashier", "theatreRegNo": "TH1"})
+	l.alice = benchIdentity("Org1MSP", "alice", map[string]string{"role": "customer"})
+	l.bob = benchIdentity("Org1MSP", "bob", map[string]string{"role": "customer"})
+	l.ok(l.admin, "init", "1")
+	l.ok(l.admin, "add_theatre", theatre)
+	l.ok(l.theatreAdmin, "add_movies", `{"movieId":"M1","movieName":"Film"}`)
+	l.ok(l.theatreAdmin, "add_shows", `{"showId":"S1","showTiming":"2019-12-29 10:00am","movieId":"M1","docType":"Shows"}`)
+	return l
+}
+
+// Runs a transaction which must succeed, gives its payload
+func (l *testLedger) ok(creator []byte, args ...string) []byte {
+	l.t.Helper()
+	response := l.invoke(creator, l.now, args...)
+	if response.Status != shim.OK {
+		l.t.Fatalf("%s failed : %s", args[0], response.Message)
+	}
+	return response.Payload
+}
+
+// Runs a transaction which must fail, gives its response
+func (l *testLedger) fail(creator []byte, args ...string) pb.Response {
+	l.t.Helper()
+	response := l.invoke(creator, l.now, args...)
+	if response.Status == shim.OK {
+		l.t.Fatalf("%s should have failed : %s", args[0], response.Payload)
+	}
+	return response
+}
@@ -20,11 +20,14 @@ package main
 import (
 	"bytes"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
+
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	pb "github.com/hyperledger/fabric/protos/peer"
 )
@@ -69,14 +72,15 @@ func get_cert(stub shim.ChaincodeStubInterface) ([]byte, error) {
 
 // ========================================================
 // Input Sanitation - dumb input checking, look for empty strings
+// and arguments longer than the configured maximum
 // ========================================================
-func sanitize_arguments(strs []string) error {
+func sanitize_arguments(strs []string, maxLength int) error {
 	for i, val := range strs {
 		if len(val) <= 0 {
 			return errors.New("Argument " + strconv.Itoa(i) + " must be a non-empty string")
 		}
-		if len(val) > 32 {
-			return errors.New("Argument " + strconv.Itoa(i) + " must be <= 32 characters")
+		if len(val) > maxLength {
+			return errors.New("Argument " + strconv.Itoa(i) + " must be <= " + strconv.Itoa(maxLength) + " characters")
 		}
 	}
 	return nil
@@ -98,7 +102,7 @@ func (t *MTA) generic_query(stub shim.ChaincodeStubInterface, args []string) pb.
 	// value := args[2]
 	// statusVal := args[3]
 
-	queryString := fmt.Sprintf(queryFrm)
+	queryString := queryFrm
 
 	queryResults, err := getQueryResultForQueryString(stub, queryString)
 	if err != nil {
@@ -128,7 +132,7 @@ func (t *MTA) generic_query_pagination(stub shim.ChaincodeStubInterface, args []
 	// value := args[2]
 	// statusVal := args[3]
 
-	queryString := fmt.Sprintf(queryFrm)
+	queryString := queryFrm
 
 	queryResults, err := getQueryResultForQueryStringWithPagination(stub, queryString, int32(pageSize), bookmark)
 	if err != nil {
@@ -273,4 +277,94 @@ func constructQueryResponseFromIterator(resultsIterator shim.StateQueryIteratorI
 	buffer.WriteString("]")
 
 	return &buffer, nil
-}
\ No newline at end of file
+}
+// ========================================================
+// Transaction Time - timestamp of the proposal, same on every endorsing peer
+// ========================================================
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// Layouts accepted for showTiming, e.g. "2019-12-29 10:30am"
+var showTimingLayouts = []string{
+	"2006-01-02 03:04pm",
+	"2006-01-02 3:04pm",
+	"2006-01-02 03:04 pm",
+	"2006-01-02 3:04 pm",
+	"2006-01-02 03:04PM",
+	"2006-01-02 3:04PM",
+	"2006-01-02 15:04",
+}
+
+// ========================================================
+// Show Timing - parse the showTiming string of a show in the time zone of its theatre
+// ========================================================
+func parseShowTiming(showTiming string, loc *time.Location) (time.Time, error) {
+	for _, layout := range showTimingLayouts {
+		start, err := time.ParseInLocation(layout, strings.TrimSpace(showTiming), loc)
+		if err == nil {
+			return start, nil
+		}
+	}
+	return time.Time{}, errors.New("Invalid show timing - " + showTiming)
+}
+
+// ========================================================
+// Show Start - start of a show or ticket, from showStart when it was recorded,
+// else from showTiming read as UTC for records written before showStart existed
+// ========================================================
+func showStartTime(showStart string, showTiming string) (time.Time, error) {
+	if showStart != "" {
+		return time.Parse(time.RFC3339, showStart)
+	}
+	return parseShowTiming(showTiming, time.UTC)
+}
+
+// ========================================================
+// Theatre Time Zone - theatres keep a fixed UTC offset such as "+05:30" rather than a
+// zone name, so every peer resolves the same local time without a time zone database
+// ========================================================
+func theatreLocation(timeZone string) (*time.Location, error) {
+	if timeZone == "" || timeZone == "Z" || timeZone == "UTC" {
+		return time.UTC, nil
+	}
+	offset, err := time.Parse("-07:00", timeZone)
+	if err != nil {
+		return nil, errors.New("Invalid time zone, expecting a UTC offset like +05:30 - " + timeZone)
+	}
+	_, seconds := offset.Zone()
+	return time.FixedZone("UTC"+timeZone, seconds), nil
+}
+
+// ========================================================
+// Transaction Scoped Id - id derived from the transaction id, so every
+// endorsing peer builds the same key for the same proposal
+// ========================================================
+func txScopedId(stub shim.ChaincodeStubInterface) string {
+	txId := stub.GetTxID()
+	if len(txId) > 16 {
+		return txId[:16]
+	}
+	return txId
+}
+
+// Error codes returned inside coded errors
+const (
+	ErrQuotaExhausted = "QUOTA_EXHAUSTED"
+	ErrPurchaseLimit  = "PURCHASE_LIMIT"
+)
+
+// ========================================================
+// Coded Error - error response with a machine readable code, {"Code":"...","Error":"..."}
+// ========================================================
+func codedError(code string, message string) pb.Response {
+	errAsBytes, _ := json.Marshal(struct {
+		Code  string `json:"Code"`
+		Error string `json:"Error"`
+	}{code, message})
+	return shim.Error(string(errAsBytes))
+}
@@ -0,0 +1,46 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Show times are read in the time zone of the theatre and checked against the transaction time
+func TestShowTimesInTheTheatreTimeZone(t *testing.T) {
+	l := newTestLedger(t, 1, 2)
+	l.fail(l.admin, "add_theatre", `{"theatreRegNo":"TH2","theatreName":"Pune","numberOfScreens":1,"docType":"Theatre","timeZone":"Asia/Kolkata"}`)
+	l.ok(l.admin, "add_theatre", `{"theatreRegNo":"TH2","theatreName":"Pune","numberOfScreens":1,"docType":"Theatre","timeZone":"+05:30"}`)
+	staff := benchIdentity("Org1MSP", "TH2", map[string]string{"role": "theatreAdmin", "theatreRegNo": "TH2"})
+	l.ok(staff, "add_movies", `{"movieId":"M1","movieName":"Film"}`)
+
+	// 09:00 UTC is 02:30pm in the theatre
+	l.fail(staff, "add_shows", `{"showId":"S8","showTiming":"2019-12-20 02:00pm","movieId":"M1","docType":"Shows"}`)
+	var show Shows
+	l.ok(staff, "add_shows", `{"showId":"S9","showTiming":"2019-12-20 03:00pm","movieId":"M1","docType":"Shows"}`)
+	json.Unmarshal(l.ok(l.alice, "get_show", `{"showId":"S9"}`), &show)
+	if show.ShowStart != "2019-12-20T15:00:00+05:30" {
+		t.Fatalf("%+v", show)
+	}
+
+	l.ok(l.alice, "book_tickets", `{"showId":"S9","seats":["A1"]}`)
+	l.now = l.now.Add(31 * time.Minute)
+	l.fail(l.alice, "book_tickets", `{"showId":"S9","seats":["A2"]}`)
+}
@@ -0,0 +1,139 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Scopes of the purchase counters of a buyer
+const (
+	CountShow = "Show"
+	CountDay  = "Day"
+)
+
+// PurchaseCounter Struct - tickets a buyer got for a show, or for the shows of a day
+type PurchaseCounter struct {
+	ObjectType string `json:"docType"` // field defined for couchdb
+	Owner      string `json:"owner"`
+	Scope      string `json:"scope"`   // Show or Day
+	ScopeId    string `json:"scopeId"` // showId or showDate
+	Tickets    int    `json:"tickets"`
+}
+
+// Error of a purchase over a limit of the config
+type limitError struct {
+	message string
+}
+
+func (e limitError) Error() string {
+	return e.message
+}
+
+// Response for an error of a purchase, limits are sent back with their code
+func purchaseError(prefix string, err error) pb.Response {
+	if _, ok := err.(limitError); ok {
+		return codedError(ErrPurchaseLimit, err.Error())
+	}
+	return shim.Error(prefix + err.Error())
+}
+
+// Checks the seats of one booking against maxTicketsPerTransaction, 0 is no limit
+func checkTransactionLimit(cfg Config, seats int) error {
+	if cfg.MaxTicketsPerTransaction > 0 && seats > cfg.MaxTicketsPerTransaction {
+		return limitError{"At most " + strconv.Itoa(cfg.MaxTicketsPerTransaction) + " tickets can be booked at once"}
+	}
+	return nil
+}
+
+// Reads a purchase counter of a buyer, a new one when the buyer has none yet
+func getPurchaseCounter(stub shim.ChaincodeStubInterface, owner string, scope string, scopeId string) (PurchaseCounter, error) {
+	counter := PurchaseCounter{ObjectType: "PurchaseCounter", Owner: owner, Scope: scope, ScopeId: scopeId}
+	counterAsBytes, err := getEntity(stub, keyPurchaseCount, owner, scope, scopeId)
+	if err != nil || counterAsBytes == nil {
+		return counter, err
+	}
+	err = json.Unmarshal(counterAsBytes, &counter)
+	return counter, err
+}
+
+// Reads the counters of a buyer for a show and for its day, failing when seats more tickets would
+// go over maxTicketsPerBuyerPerShow or maxTicketsPerBuyerPerDay. 0 is no limit.
+func checkBuyerLimits(stub shim.ChaincodeStubInterface, cfg Config, owner string, show Shows, seats int) (PurchaseCounter, PurchaseCounter, error) {
+	perShow, err := getPurchaseCounter(stub, owner, CountShow, show.ShowId)
+	if err != nil {
+		return perShow, PurchaseCounter{}, err
+	}
+	perDay, err := getPurchaseCounter(stub, owner, CountDay, show.ShowDate)
+	if err != nil {
+		return perShow, perDay, err
+	}
+	if cfg.MaxTicketsPerBuyerPerShow > 0 && perShow.Tickets+seats > cfg.MaxTicketsPerBuyerPerShow {
+		return perShow, perDay, limitError{"A buyer can have at most " + strconv.Itoa(cfg.MaxTicketsPerBuyerPerShow) +
+			" tickets for show " + show.ShowId + ", " + strconv.Itoa(perShow.Tickets) + " already"}
+	}
+	if cfg.MaxTicketsPerBuyerPerDay > 0 && perDay.Tickets+seats > cfg.MaxTicketsPerBuyerPerDay {
+		return perShow, perDay, limitError{"A buyer can have at most " + strconv.Itoa(cfg.MaxTicketsPerBuyerPerDay) +
+			" tickets for shows on " + show.ShowDate + ", " + strconv.Itoa(perDay.Tickets) + " already"}
+	}
+	return perShow, perDay, nil
+}
+
+// Counts seats more tickets for a buyer of a show, failing over the limits. Tickets bought or
+// received count, tickets passed on still count for the buyer who passed them on, so the limits
+// cannot be got around by buying and transferring again. Only cancelled tickets are taken off.
+func addPurchase(stub shim.ChaincodeStubInterface, cfg Config, owner string, show Shows, seats int) error {
+	perShow, perDay, err := checkBuyerLimits(stub, cfg, owner, show, seats)
+	if err != nil {
+		return err
+	}
+	perShow.Tickets += seats
+	perDay.Tickets += seats
+	err = putEntity(stub, perShow, keyPurchaseCount, owner, CountShow, show.ShowId)
+	if err != nil {
+		return err
+	}
+	return putEntity(stub, perDay, keyPurchaseCount, owner, CountDay, show.ShowDate)
+}
+
+// Takes the tickets of a cancelled ticket off the counters they were added to. The owner and
+// the day counted are kept on the ticket, a rescheduled show no longer has the date counted.
+func removePurchase(stub shim.ChaincodeStubInterface, ticket Tickets) error {
+	if ticket.CountedFor == "" {
+		return nil // tickets sold at the box office are not counted
+	}
+	for _, counted := range [][]string{{CountShow, ticket.ShowId}, {CountDay, ticket.CountedDate}} {
+		counter, err := getPurchaseCounter(stub, ticket.CountedFor, counted[0], counted[1])
+		if err != nil {
+			return err
+		}
+		counter.Tickets -= ticket.NumberOfTickets
+		if counter.Tickets < 0 {
+			counter.Tickets = 0
+		}
+		err = putEntity(stub, counter, keyPurchaseCount, ticket.CountedFor, counted[0], counted[1])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// A ticket cancelled after its show was moved to another day is taken off the day it was bought for
+func TestCancelAfterRescheduleFreesTheDayCounted(t *testing.T) {
+	l := newTestLedger(t, 10, 10)
+	l.ok(l.admin, "set_config", `{"config":{"maxTicketsPerBuyerPerDay":2}}`)
+	l.ok(l.theatreAdmin, "add_shows", `{"showId":"S2","showTiming":"2019-12-29 06:00pm","movieId":"M1","docType":"Shows"}`)
+
+	var ticket Tickets
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1","A2"]}`), &ticket)
+	if ticket.CountedFor == "" || ticket.CountedDate != "2019-12-29" {
+		t.Fatalf("%+v", ticket)
+	}
+	l.fail(l.alice, "book_tickets", `{"showId":"S2","seats":["A1"]}`)
+
+	l.ok(l.theatreAdmin, "reschedule_show", `{"showId":"S1","showTiming":"2019-12-30 10:00am","reason":"AC fault"}`)
+	l.ok(l.alice, "cancel_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+	l.ok(l.alice, "book_tickets", `{"showId":"S2","seats":["A1","A2"]}`)
+
+	// box office sales are not counted, cancelling them leaves the counters alone
+	json.Unmarshal(l.ok(l.cashier, "book_tickets", `{"showId":"S1","seats":["A3"],"countedFor":"x","countedDate":"2019-12-29"}`), &ticket)
+	if ticket.CountedFor != "" || ticket.CountedDate != "" {
+		t.Fatalf("%+v", ticket)
+	}
+	l.ok(l.cashier, "cancel_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+	l.fail(l.alice, "book_tickets", `{"showId":"S2","seats":["A3"]}`)
+}
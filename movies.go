@@ -0,0 +1,364 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Movie states, a movie is coming soon before its release date, running until
+// the end of its run and ended after it
+const (
+	MovieComingSoon = "ComingSoon"
+	MovieRunning    = "Running"
+	MovieEnded      = "Ended"
+	MovieRemoved    = "Removed"
+)
+
+// Start of a "2006-01-02" date in the time zone of the theatre
+func startOfDate(date string, loc *time.Location) (time.Time, error) {
+	day, err := time.ParseInLocation("2006-01-02", date, loc)
+	if err != nil {
+		return day, errors.New("Invalid date, expecting YYYY-MM-DD - " + date)
+	}
+	return day, nil
+}
+
+// Run window of a movie in the time zone of the theatre - from the start of the release date
+// until the end of the last day of the run. Movies without an end date run on, the zero end is returned.
+func movieWindow(mov Movies, loc *time.Location) (time.Time, time.Time, error) {
+	var release, end time.Time
+	var err error
+	if mov.ReleaseDate != "" {
+		release, err = startOfDate(mov.ReleaseDate, loc)
+		if err != nil {
+			return release, end, err
+		}
+	}
+	if mov.EndDate != "" {
+		end, err = startOfDate(mov.EndDate, loc)
+		if err != nil {
+			return release, end, err
+		}
+		end = end.AddDate(0, 0, 1)
+	}
+	if mov.EndedAt != "" { // run ended early by end_movie_run or remove_movie
+		endedAt, err := time.Parse(time.RFC3339, mov.EndedAt)
+		if err != nil {
+			return release, end, err
+		}
+		if end.IsZero() || endedAt.Before(end) {
+			end = endedAt
+		}
+	}
+	return release, end, nil
+}
+
+// Status of a movie at the time given
+func movieStatus(mov Movies, now time.Time, loc *time.Location) (string, error) {
+	if mov.Status == MovieRemoved {
+		return MovieRemoved, nil
+	}
+	release, end, err := movieWindow(mov, loc)
+	if err != nil {
+		return "", err
+	}
+	if now.Before(release) {
+		return MovieComingSoon, nil
+	}
+	if !end.IsZero() && greaterThanEqualCurrentDate(now, end) {
+		return MovieEnded, nil
+	}
+	return MovieRunning, nil
+}
+
+// Checks that a show starting at start falls inside the run of the movie
+func checkRunWindow(mov Movies, start time.Time, loc *time.Location) error {
+	if mov.Status == MovieRemoved {
+		return errors.New("This movie has been removed - " + mov.MovieId)
+	}
+	release, end, err := movieWindow(mov, loc)
+	if err != nil {
+		return err
+	}
+	if start.Before(release) {
+		return errors.New("Shows of " + mov.MovieId + " cannot start before its release date " + mov.ReleaseDate)
+	}
+	if !end.IsZero() && greaterThanEqualCurrentDate(start, end) {
+		return errors.New("Shows of " + mov.MovieId + " cannot start after the end of its run " + end.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// Checks that bookings are open for a movie - from preBookingDays before its release date
+func checkBookingWindow(mov Movies, now time.Time, loc *time.Location, cfg Config) error {
+	release, _, err := movieWindow(mov, loc)
+	if err != nil {
+		return err
+	}
+	opens := release.AddDate(0, 0, -cfg.PreBookingDays)
+	if now.Before(opens) {
+		return errors.New("Bookings for " + mov.MovieName + " open on " + opens.Format("2006-01-02"))
+	}
+	return nil
+}
+
+// Rebuilds the running and coming soon lists of a theatre from the status of its movies
+func setTheatreMovies(theatre *Theatre, movies []Movies) {
+	theatre.MoviesRunning = nil
+	theatre.MoviesComingSoon = nil
+	for _, mov := range movies {
+		switch mov.Status {
+		case MovieRunning:
+			theatre.MoviesRunning = append(theatre.MoviesRunning, mov)
+		case MovieComingSoon:
+			theatre.MoviesComingSoon = append(theatre.MoviesComingSoon, mov)
+		}
+	}
+}
+
+// ============================================================================================================================
+// refresh_movie_status() - move the movies of the caller's theatre between coming soon, running and ended
+//
+// The status of each movie is worked out from its release and end dates at the time of the transaction.
+//
+// Inputs - none
+// ============================================================================================================================
+func refresh_movie_status(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting refresh_movie_status")
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	theatreAsBytes, _ := getEntity(stub, keyTheatre, caller.TheatreRegNo)
+	if theatreAsBytes == nil {
+		return shim.Error("This theatre does not exists - " + caller.TheatreRegNo)
+	}
+	theatre := Theatre{}
+	json.Unmarshal(theatreAsBytes, &theatre)
+
+	loc, err := theatreLocation(theatre.TimeZone)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to refresh movie status : " + err.Error())
+	}
+
+	moviesAsBytes, err := getEntitiesByPartialKey(stub, keyMovie, theatre.TheatreRegNo)
+	if err != nil {
+		return shim.Error("Failed to refresh movie status : " + err.Error())
+	}
+	var movies []Movies
+	for _, movieAsBytes := range moviesAsBytes {
+		mov := Movies{}
+		json.Unmarshal(movieAsBytes, &mov)
+		status, err := movieStatus(mov, now, loc)
+		if err != nil {
+			return shim.Error("Failed to refresh movie status : " + err.Error())
+		}
+		if status != mov.Status {
+			fmt.Println("- movie " + mov.MovieId + " moves from " + mov.Status + " to " + status)
+			mov.Status = status
+			errPut := putEntity(stub, mov, keyMovie, mov.TheatreRegNo, mov.MovieId)
+			if errPut != nil {
+				return shim.Error("Failed to refresh movie status : " + errPut.Error())
+			}
+		}
+		movies = append(movies, mov)
+	}
+
+	setTheatreMovies(&theatre, movies)
+	errTr := putEntity(stub, theatre, keyTheatre, theatre.TheatreRegNo)
+	if errTr != nil {
+		return shim.Error("Failed to refresh movie status : " + errTr.Error())
+	}
+
+	resultAsBytes, _ := json.Marshal(movies)
+	fmt.Println("- end refresh_movie_status")
+	return shim.Success(resultAsBytes)
+}
+
+// Withdraws the shows of a movie starting after now. Fails without changing any show when
+// one of them has tickets sold, those tickets have to be cancelled first.
+func withdrawFutureShows(stub shim.ChaincodeStubInterface, mov Movies, now time.Time) ([]string, error) {
+	shows, err := getTheatreShows(stub, mov.TheatreRegNo, "")
+	if err != nil {
+		return nil, err
+	}
+	var withdrawn []Shows
+	var sold []string
+	for _, show := range shows {
+		if show.MovieId != mov.MovieId || show.ShowStatus == ShowCancelled {
+			continue
+		}
+		start, err := showStartTime(show.ShowStart, show.ShowTiming)
+		if err != nil {
+			return nil, err
+		}
+		if !start.After(now) {
+			continue
+		}
+		err = countSeats(stub, &show)
+		if err != nil {
+			return nil, err
+		}
+		if show.BookedSeat > 0 {
+			sold = append(sold, show.ShowId)
+		}
+		withdrawn = append(withdrawn, show)
+	}
+	if len(sold) > 0 {
+		return nil, errors.New("Tickets are sold for upcoming shows " + strings.Join(sold, ", ") + " of " + mov.MovieId + ", cancel them first")
+	}
+
+	var showIds []string
+	for _, show := range withdrawn {
+		show.ShowStatus = ShowCancelled
+		err = putShow(stub, show)
+		if err != nil {
+			return nil, err
+		}
+		showIds = append(showIds, show.ShowId)
+	}
+	return showIds, nil
+}
+
+// Takes a movie off the running and coming soon lists of its theatre
+func dropTheatreMovie(stub shim.ChaincodeStubInterface, mov Movies) error {
+	theatreAsBytes, err := getEntity(stub, keyTheatre, mov.TheatreRegNo)
+	if err != nil {
+		return err
+	}
+	theatre := Theatre{}
+	json.Unmarshal(theatreAsBytes, &theatre)
+
+	var movies []Movies
+	for _, m := range append(theatre.MoviesRunning, theatre.MoviesComingSoon...) {
+		if m.MovieId != mov.MovieId {
+			movies = append(movies, m)
+		}
+	}
+	setTheatreMovies(&theatre, movies)
+	return putEntity(stub, theatre, keyTheatre, theatre.TheatreRegNo)
+}
+
+// MovieRunEnd Struct - result of ending or removing a movie
+type MovieRunEnd struct {
+	Movie          Movies   `json:"movie"`
+	WithdrawnShows []string `json:"withdrawnShows"`
+}
+
+// Ends the run of a movie of the caller's theatre at the time of the transaction, status
+// becomes Ended or Removed. The movie record stays on the ledger with its history.
+func endMovie(stub shim.ChaincodeStubInterface, args []string, status string) pb.Response {
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	movieId, _ := jsonValue["movieId"].(string)
+
+	movieAsBytes, _ := getEntity(stub, keyMovie, caller.TheatreRegNo, movieId)
+	if movieAsBytes == nil {
+		return shim.Error("This movie does not exists - " + movieId)
+	}
+	mov := Movies{}
+	json.Unmarshal(movieAsBytes, &mov)
+	if mov.Status == MovieRemoved || (mov.Status == status && mov.EndedAt != "") {
+		return shim.Error("This movie is already " + mov.Status + " - " + movieId)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var result MovieRunEnd
+	result.WithdrawnShows, err = withdrawFutureShows(stub, mov, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	if mov.EndedAt == "" {
+		mov.EndedAt = now.Format(time.RFC3339)
+	}
+	mov.Status = status
+	errPut := putEntity(stub, mov, keyMovie, mov.TheatreRegNo, mov.MovieId)
+	if errPut != nil {
+		return shim.Error(errPut.Error())
+	}
+	errTr := dropTheatreMovie(stub, mov)
+	if errTr != nil {
+		return shim.Error(errTr.Error())
+	}
+
+	result.Movie = mov
+	resultAsBytes, _ := json.Marshal(result)
+	return shim.Success(resultAsBytes)
+}
+
+// ============================================================================================================================
+// end_movie_run() - end the run of a movie of the caller's theatre now and free its place in the theatre
+//
+// Upcoming shows of the movie are withdrawn. The call is refused while any of them has tickets sold.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"movieId":"value1"}
+// ============================================================================================================================
+func end_movie_run(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting end_movie_run")
+	response := endMovie(stub, args, MovieEnded)
+	fmt.Println("- end end_movie_run")
+	return response
+}
+
+// ============================================================================================================================
+// remove_movie() - take a movie off the caller's theatre, e.g. one added by mistake or not released after all
+//
+// Same as end_movie_run, but the movie is marked Removed and no shows can be added for it again.
+// The movie record is kept on the ledger for audit.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"movieId":"value1"}
+// ============================================================================================================================
+func remove_movie(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting remove_movie")
+	response := endMovie(stub, args, MovieRemoved)
+	fmt.Println("- end remove_movie")
+	return response
+}
@@ -0,0 +1,95 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Movies are coming soon until their release, booked from preBookingDays before it and end after endDate
+func TestMovieReleaseWindow(t *testing.T) {
+	l := newTestLedger(t, 1, 2)
+	l.ok(l.theatreAdmin, "add_movies", `{"movieId":"M2","movieName":"New","releaseDate":"2019-12-27","endDate":"2019-12-31"}`)
+	l.fail(l.theatreAdmin, "add_movies", `{"movieId":"M3","movieName":"Old","releaseDate":"2019-12-01","endDate":"2019-12-10"}`)
+	l.fail(l.theatreAdmin, "add_movies", `{"movieId":"M3","movieName":"Bad","releaseDate":"2019-12-21","endDate":"2019-12-20"}`)
+	var theatre Theatre
+	json.Unmarshal(l.ok(l.alice, "read", "entity", "Theatre", "TH1"), &theatre)
+	if len(theatre.MoviesComingSoon) != 1 || len(theatre.MoviesRunning) != 1 {
+		t.Fatalf("%+v", theatre)
+	}
+
+	// shows only inside the release window
+	l.fail(l.theatreAdmin, "add_shows", `{"showId":"S2","showTiming":"2019-12-26 10:00am","movieId":"M2","docType":"Shows"}`)
+	l.fail(l.theatreAdmin, "add_shows", `{"showId":"S2","showTiming":"2020-01-01 10:00am","movieId":"M2","docType":"Shows"}`)
+	l.ok(l.theatreAdmin, "add_shows", `{"showId":"S2","showTiming":"2019-12-31 11:00pm","movieId":"M2","docType":"Shows"}`)
+	response := l.fail(l.alice, "book_tickets", `{"showId":"S2","seats":["A1"]}`)
+	if !strings.Contains(response.Message, "open on 2019-12-24") {
+		t.Fatal(response.Message)
+	}
+	l.now = time.Date(2019, 12, 24, 0, 0, 0, 0, time.UTC)
+	l.ok(l.alice, "book_tickets", `{"showId":"S2","seats":["A1"]}`)
+
+	l.now = time.Date(2019, 12, 28, 0, 0, 0, 0, time.UTC)
+	l.ok(l.theatreAdmin, "refresh_movie_status")
+	json.Unmarshal(l.ok(l.alice, "read", "entity", "Theatre", "TH1"), &theatre)
+	if len(theatre.MoviesComingSoon) != 0 || len(theatre.MoviesRunning) != 2 {
+		t.Fatalf("%+v", theatre)
+	}
+	l.now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.ok(l.theatreAdmin, "refresh_movie_status")
+	var movie Movies
+	json.Unmarshal(l.ok(l.alice, "read", "entity", "Movies", "TH1", "M2"), &movie)
+	if movie.Status != MovieEnded {
+		t.Fatalf("%+v", movie)
+	}
+}
+
+// Ending the run of a movie withdraws its shows without tickets and frees their screen slots
+func TestEndMovieRun(t *testing.T) {
+	l := newTestLedger(t, 1, 2)
+	var ticket Tickets
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1"]}`), &ticket)
+	response := l.fail(l.theatreAdmin, "end_movie_run", `{"movieId":"M1"}`)
+	if !strings.Contains(response.Message, "S1") {
+		t.Fatal(response.Message)
+	}
+	l.ok(l.alice, "cancel_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+
+	var end MovieRunEnd
+	json.Unmarshal(l.ok(l.theatreAdmin, "end_movie_run", `{"movieId":"M1"}`), &end)
+	if len(end.WithdrawnShows) != 1 || end.Movie.Status != MovieEnded {
+		t.Fatalf("%+v", end)
+	}
+	l.fail(l.alice, "book_tickets", `{"showId":"S1","seats":["A2"]}`)
+	l.fail(l.theatreAdmin, "add_shows", `{"showId":"S9","showTiming":"2019-12-29 10:00am","movieId":"M1","docType":"Shows"}`)
+	l.fail(l.theatreAdmin, "end_movie_run", `{"movieId":"M1"}`)
+
+	// the 10am slot of S1 is free again
+	l.ok(l.theatreAdmin, "add_movies", `{"movieId":"M2","movieName":"Next"}`)
+	l.ok(l.theatreAdmin, "add_shows", `{"showId":"S2","showTiming":"2019-12-29 10:00am","movieId":"M2","docType":"Shows"}`)
+	l.ok(l.theatreAdmin, "remove_movie", `{"movieId":"M2"}`)
+	l.fail(l.theatreAdmin, "remove_movie", `{"movieId":"M2"}`)
+	var theatre Theatre
+	json.Unmarshal(l.ok(l.alice, "read", "entity", "Theatre", "TH1"), &theatre)
+	if len(theatre.MoviesRunning) != 0 {
+		t.Fatalf("%+v", theatre)
+	}
+}
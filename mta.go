@@ -32,22 +32,30 @@ type MTA struct {
 
 // Theatre Struct
 type Theatre struct {
-	ObjectType      string   `json:"docType"` // field defined for couchdb
-	TheatreRegNo    string   `json:"theatreRegNo"`
-	TheatreName     string   `json:"theatreName"`
-	TheatreLocation string   `json:"theatreLocation"`
-	MoviesRunning   []Movies `json:"moviesRunning"`
-	// MoviesComingSoon []Movies `json:"moviesComingSoon"`
-	NumberOfScreens int `json:"numberOfScreens"`
+	ObjectType       string         `json:"docType"` // field defined for couchdb
+	TheatreRegNo     string         `json:"theatreRegNo"`
+	TheatreName      string         `json:"theatreName"`
+	TheatreLocation  string         `json:"theatreLocation"`
+	MoviesRunning    []Movies       `json:"moviesRunning"`
+	MoviesComingSoon []Movies       `json:"moviesComingSoon"`
+	NumberOfScreens  int            `json:"numberOfScreens"`
+	ScreenLayouts    []ScreenLayout `json:"screenLayouts"` // layouts of screens without a Screen record
+	TimeZone         string         `json:"timeZone"`      // UTC offset, e.g. "+05:30"
+	MspId            string         `json:"mspId"`         // organisation of the theatre admins and cashiers
 }
 
 // Movies Struct
 type Movies struct {
-	ObjectType   string `json:"docType"` // field defined for couchdb
-	MovieId      string `json:"movieId"`
-	MovieName    string `json:"movieName"`
-	TheatreRegNo string `json:"theatreRegNo"`
-	Status       string `json:"status"`
+	ObjectType     string `json:"docType"` // field defined for couchdb
+	MovieId        string `json:"movieId"`
+	MovieName      string `json:"movieName"`
+	TheatreRegNo   string `json:"theatreRegNo"`
+	Status         string `json:"status"`
+	Format         string `json:"format"` // 2D, 3D, IMAX
+	RuntimeMinutes int    `json:"runtimeMinutes"`
+	ReleaseDate    string `json:"releaseDate"` // first day of the run, "2019-12-25"
+	EndDate        string `json:"endDate"`     // last day of the run, open ended when empty
+	EndedAt        string `json:"endedAt"`     // when the run was ended early, RFC3339
 }
 
 // Shows Struct
@@ -56,6 +64,8 @@ type Shows struct {
 	ShowId         string `json:"showId"`
 	ShowDate       string `json:"showDate"`
 	ShowTiming     string `json:"showTiming"`
+	ShowStart      string `json:"showStart"` // RFC3339 with the theatre UTC offset
+	ShowEnd        string `json:"showEnd"`   // ShowStart plus the runtime of the movie
 	TheatreRegNo   string `json:"theatreRegNo"`
 	MovieId        string `json:"movieId"`
 	TotalSeat      int    `json:"totalSeat"`
@@ -64,6 +74,7 @@ type Shows struct {
 	PricePerTicket int    `json:"pricePerTicket"`
 	ShowStatus     string `json:"showStatus"`
 	ScreenNumber   int    `json:"screenNumber"`
+	ScreenName     string `json:"screenName"`
 }
 
 // Tickets Struct
@@ -73,10 +84,26 @@ type Tickets struct {
 	ShowId          string      `json:"showId"`
 	MovieName       string      `json:"movieName"`
 	NumberOfTickets int         `json:"numberOfTickets"`
+	Seats           []string    `json:"seats"`
 	ShowTiming      string      `json:"showTiming"`
+	ShowStart       string      `json:"showStart"`
+	TheatreRegNo    string      `json:"theatreRegNo"`
 	TotalPrice      int         `json:"totalPrice"`
 	ScreenNumber    int         `json:"screenNumber"`
 	Amenities       []Amenities `json:"amenities"`
+	PriceBreakdown  []SeatPrice `json:"priceBreakdown"`
+	Owner           string      `json:"owner"`
+	Status          string      `json:"status"`
+	RefundAmount    int         `json:"refundAmount"`
+	RequestId       string      `json:"requestId"`   // id sent by the client, a retry with it gives back this ticket
+	ResalePrice     int         `json:"resalePrice"` // asking price while listed for resale, 0 otherwise
+	PreviousOwner   string      `json:"previousOwner"`
+	TransferredAt   string      `json:"transferredAt"`
+	TransferPrice   int         `json:"transferPrice"` // price paid on resale, 0 for a transfer
+	CheckedInAt     string      `json:"checkedInAt"`
+	VerifyCode      string      `json:"verificationCode"` // shown at the gate, changes with the owner
+	CountedFor      string      `json:"countedFor"`       // owner whose purchase counters hold the ticket, empty for the box office
+	CountedDate     string      `json:"countedDate"`      // show date of the day counter, the show may be moved since
 }
 
 // Amenities Struct
@@ -91,9 +118,19 @@ type Amenities struct {
 type Accessories struct {
 	ObjectType   string `json:"docType"` // field defined for couchdb
 	Asset        string `json:"asset"`
+	TheatreRegNo string `json:"theatreRegNo"`
 	TotalQty     int    `json:"totalQty"`
 	ForDate      string `json:"forDate"`
 	AvailableQty int    `json:"availableQty"`
+	GrantedQty   int    `json:"grantedQty"`
+}
+
+// ExchangeResult Struct
+type ExchangeResult struct {
+	TicketId     string   `json:"ticketId"`
+	Exchanged    []string `json:"exchanged"`
+	NotExchanged []string `json:"notExchanged"`
+	AvailableQty int      `json:"availableQty"`
 }
 
 // ============================================================================================================================
@@ -137,6 +174,36 @@ func (t *MTA) Init(stub shim.ChaincodeStubInterface) pb.Response {
 		return shim.Error(err.Error()) //self-test fail
 	}
 
+	// organisation of the instantiating client runs the platform
+	err = putPlatform(stub)
+	if err != nil {
+		return shim.Error("Error retrieving client identity : " + err.Error())
+	}
+
+	// default refund policy for cancelled tickets
+	policyAsBytes, err := getEntity(stub, keyRefundPolicy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if policyAsBytes == nil {
+		err = putEntity(stub, defaultRefundPolicy(), keyRefundPolicy)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	// configuration of the business limits, kept on upgrade
+	cfgAsBytes, err := getEntity(stub, keyConfig)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if cfgAsBytes == nil {
+		err = putEntity(stub, defaultConfig(), keyConfig)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
 	fmt.Println(" - ready for action") //self-test pass
 	return shim.Success(nil)
 }
@@ -149,6 +216,12 @@ func (t *MTA) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 	fmt.Println(" ")
 	fmt.Println("starting invoke, for - " + function)
 
+	// check the caller holds a role allowed to call the function
+	err := authorize(stub, function)
+	if err != nil {
+		return codedError(ErrAccessDenied, err.Error())
+	}
+
 	// Handle different functions
 	if function == "init" { //initialize the chaincode state, used as reset
 		return t.Init(stub)
@@ -172,6 +245,80 @@ func (t *MTA) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
 		return book_tickets(stub, args)
 	} else if function == "exchange_water" { //exchange water with soda
 		return exchange_water(stub, args)
+	} else if function == "hold_seats" { //hold seats while the payment is made
+		return hold_seats(stub, args)
+	} else if function == "confirm_hold" { //book the seats of a hold
+		return confirm_hold(stub, args)
+	} else if function == "release_hold" { //give back the seats of a hold
+		return release_hold(stub, args)
+	} else if function == "transfer_ticket" { //give a ticket to another buyer
+		return transfer_ticket(stub, args)
+	} else if function == "list_ticket_for_resale" { //offer a ticket for resale
+		return list_ticket_for_resale(stub, args)
+	} else if function == "cancel_resale" { //take a ticket off resale
+		return cancel_resale(stub, args)
+	} else if function == "buy_resale_ticket" { //buy a ticket listed for resale
+		return buy_resale_ticket(stub, args)
+	} else if function == "get_resale_tickets" { //read tickets of a show listed for resale
+		return get_resale_tickets(stub, args)
+	} else if function == "check_in_ticket" { //admit a ticket at the gate
+		return check_in_ticket(stub, args)
+	} else if function == "get_show_admissions" { //read seats admitted and no-shows of a show
+		return get_show_admissions(stub, args)
+	} else if function == "join_waitlist" { //wait for seats of a sold out show
+		return join_waitlist(stub, args)
+	} else if function == "leave_waitlist" { //stop waiting for seats of a show
+		return leave_waitlist(stub, args)
+	} else if function == "get_waitlist" { //read the waitlist of a show
+		return get_waitlist(stub, args)
+	} else if function == "expire_offers" { //pass seats of lapsed waitlist offers to the next buyers
+		return expire_offers(stub, args)
+	} else if function == "get_gate_snapshot" { //read codes of tickets valid at a theatre on a day
+		return get_gate_snapshot(stub, args)
+	} else if function == "get_seat_map" { //read seat map of a show
+		return get_seat_map(stub, args)
+	} else if function == "cancel_ticket" { //cancel a ticket and refund it
+		return cancel_ticket(stub, args)
+	} else if function == "set_refund_policy" { //set the refund policy for cancellations
+		return set_refund_policy(stub, args)
+	} else if function == "get_show" { //read a show with its seats counted
+		return get_show(stub, args)
+	} else if function == "get_theatre_shows" { //read all shows of a theatre
+		return get_theatre_shows(stub, args)
+	} else if function == "get_theatre_movies" { //read all movies of a theatre
+		return get_theatre_movies(stub, args)
+	} else if function == "migrate_keys" { //move flat keys to composite keys
+		return migrate_keys(stub, args)
+	} else if function == "get_my_tickets" { //read the tickets of the caller
+		return get_my_tickets(stub, args)
+	} else if function == "set_price_plan" { //set the price rules of a theatre
+		return set_price_plan(stub, args)
+	} else if function == "get_price_plan" { //read the price rules of a theatre
+		return get_price_plan(stub, args)
+	} else if function == "set_config" { //change the business limits of the platform or of a theatre
+		return set_config(stub, args)
+	} else if function == "get_config" { //read the business limits in force
+		return get_config(stub, args)
+	} else if function == "add_screen" { //add or change a screen of a theatre
+		return add_screen(stub, args)
+	} else if function == "get_theatre_screens" { //read all screens of a theatre
+		return get_theatre_screens(stub, args)
+	} else if function == "get_screen_utilization" { //read the use of each screen on a day
+		return get_screen_utilization(stub, args)
+	} else if function == "reschedule_show" { //move a show to another time or screen
+		return reschedule_show(stub, args)
+	} else if function == "cancel_show" { //cancel a show and refund its tickets
+		return cancel_show(stub, args)
+	} else if function == "process_refund_batch" { //refund the next batch of tickets of a cancelled show
+		return process_refund_batch(stub, args)
+	} else if function == "get_job" { //read the progress of a batch job
+		return get_job(stub, args)
+	} else if function == "refresh_movie_status" { //move movies between coming soon, running and ended
+		return refresh_movie_status(stub, args)
+	} else if function == "end_movie_run" { //end the run of a movie
+		return end_movie_run(stub, args)
+	} else if function == "remove_movie" { //take a movie off a theatre
+		return remove_movie(stub, args)
 	}
 
 	// error out
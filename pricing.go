@@ -0,0 +1,296 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Day types a price rule can be limited to
+const (
+	DayWeekday = "weekday"
+	DayWeekend = "weekend"
+	DayHoliday = "holiday"
+)
+
+// PricePlan Struct - price rules of a theatre
+type PricePlan struct {
+	ObjectType     string         `json:"docType"` // field defined for couchdb
+	TheatreRegNo   string         `json:"theatreRegNo"`
+	BasePrice      int            `json:"basePrice"`
+	CategoryPrices map[string]int `json:"categoryPrices"` // base price by seat category, in place of basePrice
+	Rules          []PriceRule    `json:"rules"`
+	Holidays       []string       `json:"holidays"` // holiday calendar, "2019-12-25"
+}
+
+// PriceRule Struct - adjustment applied when every condition set on the rule matches the seat
+type PriceRule struct {
+	Name         string `json:"name"`
+	FromTime     string `json:"fromTime"` // time band start "10:00", inclusive
+	ToTime       string `json:"toTime"`   // time band end "12:00", exclusive
+	DayType      string `json:"dayType"`  // weekday, weekend or holiday
+	SeatCategory string `json:"seatCategory"`
+	Format       string `json:"format"`    // movie format, 2D, 3D, IMAX
+	Surcharge    int    `json:"surcharge"` // amount added to the price, negative for a discount
+	Percent      int    `json:"percent"`   // percent of the base price added, negative for a discount
+}
+
+// PriceComponent Struct
+type PriceComponent struct {
+	Label  string `json:"label"`
+	Amount int    `json:"amount"`
+}
+
+// SeatPrice Struct - price of one seat with the components it is made of
+type SeatPrice struct {
+	SeatNumber   string           `json:"seatNumber"`
+	SeatCategory string           `json:"seatCategory"`
+	Components   []PriceComponent `json:"components"`
+	Price        int              `json:"price"`
+}
+
+// Price plan used by theatres without one, the old rule of 100 for morning shows and 180 for the rest
+func defaultPricePlan(theatreRegNo string) PricePlan {
+	var plan PricePlan
+	plan.ObjectType = "PricePlan"
+	plan.TheatreRegNo = theatreRegNo
+	plan.BasePrice = 180
+	plan.Rules = []PriceRule{
+		{Name: "Morning show", FromTime: "00:00", ToTime: "12:00", Surcharge: -80},
+	}
+	return plan
+}
+
+// Reads the price plan of a theatre
+func getPricePlan(stub shim.ChaincodeStubInterface, theatreRegNo string) (PricePlan, error) {
+	planAsBytes, err := getEntity(stub, keyPricePlan, theatreRegNo)
+	if err != nil {
+		return PricePlan{}, err
+	}
+	if planAsBytes == nil {
+		return defaultPricePlan(theatreRegNo), nil
+	}
+	var plan PricePlan
+	err = json.Unmarshal(planAsBytes, &plan)
+	return plan, err
+}
+
+// Minutes since midnight of a "15:04" time
+func minuteOfDay(hhmm string) (int, error) {
+	t, err := time.Parse("15:04", hhmm)
+	if err != nil {
+		return 0, errors.New("Invalid time, expecting HH:MM - " + hhmm)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Whether the rule matches a seat of the given category for a show starting at start (theatre local time)
+func ruleMatches(plan PricePlan, rule PriceRule, start time.Time, seatCategory string, format string) bool {
+	if rule.SeatCategory != "" && !strings.EqualFold(rule.SeatCategory, seatCategory) {
+		return false
+	}
+	if rule.Format != "" && !strings.EqualFold(rule.Format, format) {
+		return false
+	}
+	if rule.DayType != "" {
+		holiday := false
+		for _, date := range plan.Holidays {
+			if date == start.Format("2006-01-02") {
+				holiday = true
+			}
+		}
+		weekend := start.Weekday() == time.Saturday || start.Weekday() == time.Sunday
+		switch rule.DayType {
+		case DayHoliday:
+			if !holiday {
+				return false
+			}
+		case DayWeekend:
+			if !weekend {
+				return false
+			}
+		case DayWeekday:
+			if weekend || holiday {
+				return false
+			}
+		}
+	}
+	if rule.FromTime != "" && rule.ToTime != "" {
+		from, _ := minuteOfDay(rule.FromTime)
+		to, _ := minuteOfDay(rule.ToTime)
+		minute := start.Hour()*60 + start.Minute()
+		if from <= to && (minute < from || minute >= to) {
+			return false
+		}
+		if from > to && minute < from && minute >= to { // band crossing midnight
+			return false
+		}
+	}
+	return true
+}
+
+// Works out the price of one seat from the plan, component by component
+func priceSeat(plan PricePlan, start time.Time, seatNumber string, seatCategory string, format string) SeatPrice {
+	var price SeatPrice
+	price.SeatNumber = seatNumber
+	price.SeatCategory = seatCategory
+
+	base, ok := plan.CategoryPrices[seatCategory]
+	if !ok {
+		base = plan.BasePrice
+	}
+	price.Components = append(price.Components, PriceComponent{Label: "Base " + seatCategory, Amount: base})
+	price.Price = base
+
+	for _, rule := range plan.Rules {
+		if !ruleMatches(plan, rule, start, seatCategory, format) {
+			continue
+		}
+		amount := rule.Surcharge + base*rule.Percent/100
+		price.Components = append(price.Components, PriceComponent{Label: rule.Name, Amount: amount})
+		price.Price += amount
+	}
+	if price.Price < 0 {
+		price.Price = 0
+	}
+	return price
+}
+
+// Checks the rules of a plan before it is written
+func validatePricePlan(plan PricePlan) error {
+	if plan.BasePrice < 0 {
+		return errors.New("basePrice cannot be negative")
+	}
+	for category, price := range plan.CategoryPrices {
+		if price < 0 {
+			return errors.New("Price of seat category " + category + " cannot be negative")
+		}
+	}
+	for _, rule := range plan.Rules {
+		if rule.Name == "" {
+			return errors.New("Every price rule needs a name")
+		}
+		if (rule.FromTime == "") != (rule.ToTime == "") {
+			return errors.New("Price rule " + rule.Name + " needs both fromTime and toTime")
+		}
+		if rule.FromTime != "" {
+			if _, err := minuteOfDay(rule.FromTime); err != nil {
+				return err
+			}
+			if _, err := minuteOfDay(rule.ToTime); err != nil {
+				return err
+			}
+		}
+		if rule.DayType != "" && rule.DayType != DayWeekday && rule.DayType != DayWeekend && rule.DayType != DayHoliday {
+			return errors.New("dayType of price rule " + rule.Name + " must be weekday, weekend or holiday")
+		}
+		if rule.Percent < -100 {
+			return errors.New("percent of price rule " + rule.Name + " cannot be below -100")
+		}
+	}
+	for _, date := range plan.Holidays {
+		if _, err := time.Parse("2006-01-02", date); err != nil {
+			return errors.New("Invalid holiday date, expecting YYYY-MM-DD - " + date)
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// set_price_plan() - replace the price plan of the caller's theatre
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"basePrice":180,"categoryPrices":{"Recliner":350},"holidays":["2019-12-25"],
+//   "rules":[{"name":"Morning show","fromTime":"00:00","toTime":"12:00","surcharge":-80},
+//            {"name":"Weekend","dayType":"weekend","percent":20},
+//            {"name":"3D glasses","format":"3D","surcharge":30}]}
+// ============================================================================================================================
+func set_price_plan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting set_price_plan")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	theatreAsBytes, _ := getEntity(stub, keyTheatre, caller.TheatreRegNo)
+	if theatreAsBytes == nil {
+		return shim.Error("This theatre does not exists - " + caller.TheatreRegNo)
+	}
+
+	var plan PricePlan
+	err = json.Unmarshal([]byte(args[0]), &plan)
+	if err != nil {
+		return shim.Error("Invalid price plan : " + err.Error())
+	}
+	err = validatePricePlan(plan)
+	if err != nil {
+		return shim.Error("Invalid price plan : " + err.Error())
+	}
+	plan.ObjectType = "PricePlan"
+	plan.TheatreRegNo = caller.TheatreRegNo
+
+	errPut := putEntity(stub, plan, keyPricePlan, plan.TheatreRegNo)
+	if errPut != nil {
+		return shim.Error("Failed to set price plan : " + errPut.Error())
+	}
+
+	fmt.Println("- end set_price_plan")
+	return shim.Success(nil)
+}
+
+// ============================================================================================================================
+// get_price_plan() - read the price plan of a theatre
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"theatreRegNo":"value1"}
+// ============================================================================================================================
+func get_price_plan(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_price_plan")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	theatreRegNo, _ := jsonValue["theatreRegNo"].(string)
+
+	plan, err := getPricePlan(stub, theatreRegNo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	planAsBytes, _ := json.Marshal(plan)
+
+	fmt.Println("- end get_price_plan")
+	return shim.Success(planAsBytes)
+}
@@ -0,0 +1,77 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Seats are priced from the base of their category and every rule matching the show
+func TestPriceSeat(t *testing.T) {
+	plan := PricePlan{BasePrice: 200, CategoryPrices: map[string]int{"Recliner": 400}, Holidays: []string{"2019-12-25"}}
+	plan.Rules = []PriceRule{
+		{Name: "Morning show", FromTime: "00:00", ToTime: "12:00", Surcharge: -50},
+		{Name: "Late night", FromTime: "22:00", ToTime: "02:00", Surcharge: -20},
+		{Name: "Weekend", DayType: DayWeekend, Percent: 10},
+		{Name: "Holiday", DayType: DayHoliday, Percent: 25},
+		{Name: "3D glasses", Format: "3D", Surcharge: 30},
+	}
+	for _, c := range []struct {
+		start    string
+		category string
+		format   string
+		price    int
+	}{
+		{"2019-12-23T10:00:00Z", "Standard", "2D", 150}, // Monday morning
+		{"2019-12-23T12:00:00Z", "Standard", "2D", 200},
+		{"2019-12-23T23:30:00Z", "Standard", "3d", 210},
+		{"2019-12-28T18:00:00Z", "Standard", "2D", 220}, // Saturday
+		{"2019-12-28T18:00:00Z", "Recliner", "2D", 440},
+		{"2019-12-25T18:00:00Z", "Standard", "3D", 280}, // Wednesday, a holiday
+	} {
+		start, _ := time.Parse(time.RFC3339, c.start)
+		price := priceSeat(plan, start, "A1", c.category, c.format)
+		total := 0
+		for _, component := range price.Components {
+			total += component.Amount
+		}
+		if price.Price != c.price || total != c.price {
+			t.Errorf("%s %s %s : %+v, want %d", c.start, c.category, c.format, price, c.price)
+		}
+	}
+}
+
+// Tickets are priced by the plan of the theatre, theatres without one keep the old prices
+func TestBookingPricedByThePlan(t *testing.T) {
+	l := newTestLedger(t, 1, 2)
+	var ticket Tickets
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1"]}`), &ticket)
+	if ticket.TotalPrice != 100 {
+		t.Fatalf("%+v", ticket)
+	}
+
+	l.fail(l.theatreAdmin, "set_price_plan", `{"basePrice":150,"rules":[{"name":"Weekend","dayType":"sunday","percent":20}]}`)
+	l.fail(l.alice, "set_price_plan", `{"basePrice":150}`)
+	l.ok(l.theatreAdmin, "set_price_plan", `{"basePrice":150,"rules":[{"name":"Weekend","dayType":"weekend","percent":20}]}`)
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A2"]}`), &ticket)
+	if ticket.TotalPrice != 180 || len(ticket.PriceBreakdown) != 1 || len(ticket.PriceBreakdown[0].Components) != 2 {
+		t.Fatalf("%+v", ticket)
+	}
+}
@@ -20,6 +20,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -42,6 +43,11 @@ import (
 //  key
 //  "abc"
 //
+// Entities are read by object type and key attributes
+//  0       ,    1       ,   2
+//  _       , objectType , attribute ...
+//  "entity",  "Shows"   , "show1"
+//
 // Returns - string
 // ============================================================================================================================
 func read(stub shim.ChaincodeStubInterface, args []string) pb.Response {
@@ -49,17 +55,32 @@ func read(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var err error
 	fmt.Println("starting read")
 
-	if len(args) != 2 {
+	if len(args) < 2 {
 		return shim.Error("Incorrect number of arguments. Expecting key of the var to query")
 	}
 
 	// input sanitation
-	err = sanitize_arguments(args)
+	cfg, err := getConfig(stub, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	// key attributes are ids minted by the chaincode or owner ids, longer than the other arguments
+	if len(args) > 2 {
+		err = sanitize_arguments(args[:2], cfg.MaxArgumentLength)
+		if err == nil {
+			err = sanitize_arguments(args[2:], maxKeyAttributeLength)
+		}
+	} else {
+		err = sanitize_arguments(args, cfg.MaxArgumentLength)
+	}
 	if err != nil {
 		return shim.Error(err.Error())
 	}
 
-	key = args[1]
+	key, err = ledgerKey(stub, args[1:])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	valAsbytes, err := stub.GetState(key) //get the var from ledger
 	if err != nil {
 		jsonResp = "{\"Error\":\"Failed to get state for " + key + "\"}"
@@ -92,7 +113,11 @@ func read_owner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	}
 
 	// input sanitation
-	err = sanitize_arguments(args)
+	cfg, err := getConfig(stub, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = sanitize_arguments(args, cfg.MaxArgumentLength)
 	if err != nil {
 		return shim.Error(err.Error())
 	}
@@ -139,6 +164,8 @@ func read_owner(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 //  0
 //  id
 //  "m01490985296352SjAyM"
+//
+// Entities are read by object type and key attributes, same as read()
 // ============================================================================================================================
 func getHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	/*type AuditHistory struct {
@@ -148,11 +175,18 @@ func getHistory(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var history []AuditHistory
 	var project Asset
 	*/
-	if len(args) != 2 {
+	if len(args) < 2 {
 		return shim.Error("Incorrect number of arguments. Expecting 1")
 	}
 
 	txnId := strings.Replace(args[1], "\"", "", -1) //rename for funsies args[1]
+	if len(args) > 2 {
+		key, err := ledgerKey(stub, args[1:])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		txnId = key
+	}
 	fmt.Printf("- start getHistoryForTxn: %s\n", txnId)
 
 	// Get History
@@ -273,3 +307,88 @@ func getTxnByRange(stub shim.ChaincodeStubInterface, args []string) pb.Response
 
 	return shim.Success(buffer.Bytes())
 }
+
+// MyTickets Struct
+type MyTickets struct {
+	OwnerId      string    `json:"ownerId"`  // id to give to a friend transferring a ticket to the caller
+	Upcoming     []Tickets `json:"upcoming"` // booked tickets of shows yet to start
+	Past         []Tickets `json:"past"`     // tickets of shows started, and tickets cancelled or checked in
+	RecordsCount int32     `json:"recordsCount"`
+	Bookmark     string    `json:"bookmark"`
+}
+
+// ============================================================================================================================
+// get_my_tickets - read the tickets owned by the caller, a page at a time, ordered by show time
+//
+// Shows Off GetStateByPartialCompositeKeyWithPagination() - paging through the owner~ticket index
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"pageSize":10,"bookmark":""}
+// ============================================================================================================================
+func get_my_tickets(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_my_tickets")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var request struct {
+		PageSize int32  `json:"pageSize"`
+		Bookmark string `json:"bookmark"`
+	}
+	json.Unmarshal([]byte(args[0]), &request)
+	if request.PageSize <= 0 {
+		request.PageSize = 10
+	}
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	resultsIterator, responseMetadata, err := stub.GetStateByPartialCompositeKeyWithPagination(indexOwnerTicket, []string{caller.OwnerId()}, request.PageSize, request.Bookmark)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	defer resultsIterator.Close()
+
+	myTickets := MyTickets{OwnerId: caller.OwnerId(), Upcoming: []Tickets{}, Past: []Tickets{}}
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		tktAsBytes, err := getEntity(stub, keyTicket, keyParts[2])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if tktAsBytes == nil {
+			continue
+		}
+		ticket := Tickets{}
+		json.Unmarshal(tktAsBytes, &ticket)
+		// cancelled tickets keep their owner~ticket entry so the buyer still sees them, under past
+		start, err := showStartTime(ticket.ShowStart, ticket.ShowTiming)
+		if err == nil && start.After(now) && ticket.Status == TicketBooked {
+			myTickets.Upcoming = append(myTickets.Upcoming, ticket)
+		} else {
+			myTickets.Past = append(myTickets.Past, ticket)
+		}
+	}
+	myTickets.RecordsCount = responseMetadata.FetchedRecordsCount
+	myTickets.Bookmark = responseMetadata.Bookmark
+
+	myTicketsAsBytes, _ := json.Marshal(myTickets)
+	fmt.Println("- end get_my_tickets")
+	return shim.Success(myTicketsAsBytes)
+}
@@ -0,0 +1,55 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Buyers read their own tickets by show time, a page at a time
+func TestMyTickets(t *testing.T) {
+	l := newTestLedger(t, 1, 3)
+	l.ok(l.theatreAdmin, "add_shows", `{"showId":"S2","showTiming":"2019-12-21 10:00am","movieId":"M1","docType":"Shows"}`)
+	var later, cancelled Tickets
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1"]}`), &later)
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A2"]}`), &cancelled)
+	l.ok(l.alice, "book_tickets", `{"showId":"S2","seats":["A1"]}`)
+	l.ok(l.bob, "book_tickets", `{"showId":"S2","seats":["A2"]}`)
+	l.ok(l.alice, "cancel_ticket", `{"ticketId":"`+cancelled.TicketId+`"}`)
+	l.now = l.now.AddDate(0, 0, 2)
+
+	var mine MyTickets
+	json.Unmarshal(l.ok(l.alice, "get_my_tickets", `{}`), &mine)
+	if mine.RecordsCount != 3 || len(mine.Upcoming) != 1 || len(mine.Past) != 2 || mine.Upcoming[0].TicketId != later.TicketId {
+		t.Fatalf("%+v", mine)
+	}
+	if mine.OwnerId != later.Owner || mine.Past[0].ShowId != "S2" {
+		t.Fatalf("%+v", mine)
+	}
+
+	json.Unmarshal(l.ok(l.alice, "get_my_tickets", `{"pageSize":2}`), &mine)
+	if mine.RecordsCount != 2 || mine.Bookmark == "" || len(mine.Past) != 1 || mine.Past[0].ShowId != "S2" {
+		t.Fatalf("%+v", mine)
+	}
+	next, _ := json.Marshal(map[string]interface{}{"pageSize": 2, "bookmark": mine.Bookmark}) // the bookmark is a composite key
+	json.Unmarshal(l.ok(l.alice, "get_my_tickets", string(next)), &mine)
+	if mine.RecordsCount != 1 || len(mine.Past) != 1 || mine.Past[0].TicketId != cancelled.TicketId {
+		t.Fatalf("%+v", mine)
+	}
+}
@@ -0,0 +1,279 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Ticket states
+const (
+	TicketBooked    = "Booked"
+	TicketCancelled = "Cancelled"
+	TicketRedeemed  = "Redeemed"
+)
+
+// RefundPolicy Struct
+type RefundPolicy struct {
+	ObjectType string       `json:"docType"` // field defined for couchdb
+	Tiers      []RefundTier `json:"tiers"`
+}
+
+// RefundTier Struct - cancelling at least HoursBeforeShow hours before the show refunds RefundPercent of the price
+type RefundTier struct {
+	HoursBeforeShow int `json:"hoursBeforeShow"`
+	RefundPercent   int `json:"refundPercent"`
+}
+
+// Refunds Struct
+type Refunds struct {
+	ObjectType    string `json:"docType"` // field defined for couchdb
+	RefundId      string `json:"refundId"`
+	TicketId      string `json:"ticketId"`
+	ShowId        string `json:"showId"`
+	Owner         string `json:"owner"`
+	TotalPrice    int    `json:"totalPrice"`
+	RefundPercent int    `json:"refundPercent"`
+	RefundAmount  int    `json:"refundAmount"`
+	CancelledAt   string `json:"cancelledAt"`
+	Reason        string `json:"reason"`
+	TxId          string `json:"txId"`
+}
+
+// Refund policy used until a policy is set on the ledger
+func defaultRefundPolicy() RefundPolicy {
+	var policy RefundPolicy
+	policy.ObjectType = "RefundPolicy"
+	policy.Tiers = []RefundTier{
+		{HoursBeforeShow: 24, RefundPercent: 100},
+		{HoursBeforeShow: 4, RefundPercent: 50},
+	}
+	return policy
+}
+
+// Reads the refund policy from the ledger
+func getRefundPolicy(stub shim.ChaincodeStubInterface) (RefundPolicy, error) {
+	policyAsBytes, err := getEntity(stub, keyRefundPolicy)
+	if err != nil {
+		return RefundPolicy{}, err
+	}
+	if policyAsBytes == nil {
+		return defaultRefundPolicy(), nil
+	}
+	var policy RefundPolicy
+	err = json.Unmarshal(policyAsBytes, &policy)
+	return policy, err
+}
+
+// Percentage of the price refunded when cancelling with the given time left before the show
+func refundPercent(policy RefundPolicy, beforeShow time.Duration) int {
+	percent := 0
+	best := -1
+	for _, tier := range policy.Tiers {
+		if beforeShow >= time.Duration(tier.HoursBeforeShow)*time.Hour && tier.HoursBeforeShow > best {
+			best = tier.HoursBeforeShow
+			percent = tier.RefundPercent
+		}
+	}
+	return percent
+}
+
+// Gives the seats of a ticket back, the show counts them as free again. Gives the seats freed.
+func releaseTicketSeats(stub shim.ChaincodeStubInterface, ticket Tickets) (SeatMap, error) {
+	sm, err := getSeats(stub, ticket.ShowId, ticket.Seats)
+	if err != nil {
+		return sm, err
+	}
+	for i, seat := range sm.Seats {
+		if seat.TicketId == ticket.TicketId {
+			sm.Seats[i].Status = SeatFree
+			sm.Seats[i].TicketId = ""
+		}
+	}
+	return sm, putSeats(stub, sm)
+}
+
+// Cancels a ticket and records the refund of percent of its price into the ledger
+func refundTicket(stub shim.ChaincodeStubInterface, ticket Tickets, percent int, now time.Time, reason string) (Refunds, error) {
+	var refund Refunds
+	refund.ObjectType = "Refunds"
+	refund.RefundId = "RF" + ticket.TicketId
+	refund.TicketId = ticket.TicketId
+	refund.ShowId = ticket.ShowId
+	refund.Owner = ticket.Owner
+	refund.TotalPrice = ticket.TotalPrice
+	refund.RefundPercent = percent
+	refund.RefundAmount = ticket.TotalPrice * percent / 100
+	refund.CancelledAt = now.Format(time.RFC3339)
+	refund.Reason = reason
+	refund.TxId = stub.GetTxID()
+
+	if ticket.ResalePrice > 0 {
+		err := delIndex(stub, indexResale, ticket.ShowId, ticket.TicketId)
+		if err != nil {
+			return refund, err
+		}
+		ticket.ResalePrice = 0
+	}
+	err := removePurchase(stub, ticket)
+	if err != nil {
+		return refund, err
+	}
+	ticket.Status = TicketCancelled
+	ticket.RefundAmount = refund.RefundAmount
+	err = putEntity(stub, ticket, keyTicket, ticket.TicketId) // update the ticket details into the ledger
+	if err != nil {
+		return refund, err
+	}
+	err = putEntity(stub, refund, keyRefund, refund.TicketId) // write the refund details into the ledger
+	return refund, err
+}
+
+// ============================================================================================================================
+// cancel_ticket() - cancel a booked ticket, release its seats and record the refund into ledger
+//
+// Shows Off PutState() - writting a key/value into the ledger
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"ticketId":"value1"}
+// ============================================================================================================================
+func cancel_ticket(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	var ticketId string
+	fmt.Println("starting cancel_ticket")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		fmt.Printf("INVOKE: Error retrieving cert: %s", err)
+		return shim.Error("Error retrieving cert")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	ticketId, _ = jsonValue["ticketId"].(string)
+
+	tktAsBytes, _ := getEntity(stub, keyTicket, ticketId)
+	if tktAsBytes == nil {
+		return shim.Error("This ticket does not exists - " + ticketId)
+	}
+	ticket := Tickets{}
+	json.Unmarshal(tktAsBytes, &ticket)
+	if ticket.Owner != caller.OwnerId() {
+		return codedError(ErrAccessDenied, "Only the owner of the ticket can cancel it - "+ticketId)
+	}
+	if ticket.Status == TicketCancelled {
+		return shim.Error("This ticket is already cancelled - " + ticketId)
+	}
+	if ticket.Status == TicketRedeemed {
+		return shim.Error("This ticket was checked in at " + ticket.CheckedInAt + " and cannot be cancelled - " + ticketId)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to cancel ticket : " + err.Error())
+	}
+	showStart, err := showStartTime(ticket.ShowStart, ticket.ShowTiming)
+	if err != nil {
+		return shim.Error("Failed to cancel ticket : " + err.Error())
+	}
+	if !showStart.After(now) {
+		return shim.Error("Tickets cannot be cancelled once the show has started - " + ticketId)
+	}
+
+	shAsBytes, _ := getEntity(stub, keyShow, ticket.ShowId)
+	show := Shows{}
+	json.Unmarshal(shAsBytes, &show)
+	if show.ShowStatus == ShowCancelled {
+		return shim.Error("The show of this ticket is cancelled, it is refunded in full by job " + showRefundJobId(show.ShowId))
+	}
+
+	policy, err := getRefundPolicy(stub)
+	if err != nil {
+		return shim.Error("Failed to cancel ticket : " + err.Error())
+	}
+
+	freed, err := releaseTicketSeats(stub, ticket)
+	if err != nil {
+		return shim.Error("Failed to cancel ticket : " + err.Error())
+	}
+
+	refund, err := refundTicket(stub, ticket, refundPercent(policy, showStart.Sub(now)), now, "Cancelled by ticket holder")
+	if err != nil {
+		return shim.Error("Failed to cancel ticket : " + err.Error())
+	}
+
+	// seats given back go to the waitlist of the show first
+	err = offerToWaitlist(stub, show, freed, now)
+	if err != nil {
+		return shim.Error("Failed to cancel ticket : " + err.Error())
+	}
+	refundAsBytes, _ := json.Marshal(refund)
+
+	fmt.Println("- end cancel_ticket")
+	return shim.Success(refundAsBytes)
+}
+
+// ============================================================================================================================
+// set_refund_policy() - replace the refund policy on the ledger
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"tiers":[{"hoursBeforeShow":24,"refundPercent":100},{"hoursBeforeShow":4,"refundPercent":50}]}
+// ============================================================================================================================
+func set_refund_policy(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting set_refund_policy")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	var policy RefundPolicy
+	err := json.Unmarshal([]byte(args[0]), &policy)
+	if err != nil {
+		return shim.Error("Invalid refund policy : " + err.Error())
+	}
+	for _, tier := range policy.Tiers {
+		if tier.HoursBeforeShow < 0 || tier.RefundPercent < 0 || tier.RefundPercent > 100 {
+			return shim.Error("Refund tiers need hoursBeforeShow >= 0 and refundPercent between 0 and 100")
+		}
+	}
+	sort.Slice(policy.Tiers, func(i, j int) bool {
+		return policy.Tiers[i].HoursBeforeShow > policy.Tiers[j].HoursBeforeShow
+	})
+	policy.ObjectType = "RefundPolicy"
+
+	errPut := putEntity(stub, policy, keyRefundPolicy)
+	if errPut != nil {
+		return shim.Error("Failed to set refund policy : " + errPut.Error())
+	}
+
+	fmt.Println("- end set_refund_policy")
+	return shim.Success(nil)
+}
@@ -0,0 +1,69 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Cancelled tickets are refunded by the tier of the hours left before the show, the seats are free again
+func TestCancelTicketRefund(t *testing.T) {
+	l := newTestLedger(t, 1, 4)
+	book := func(seat string) Tickets {
+		var ticket Tickets
+		json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["`+seat+`"]}`), &ticket)
+		return ticket
+	}
+	cancel := func(ticket Tickets) Refunds {
+		var refund Refunds
+		json.Unmarshal(l.ok(l.alice, "cancel_ticket", `{"ticketId":"`+ticket.TicketId+`"}`), &refund)
+		return refund
+	}
+
+	ticket := book("A1")
+	l.fail(l.bob, "cancel_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+	refund := cancel(ticket)
+	if refund.RefundPercent != 100 || refund.RefundAmount != ticket.TotalPrice || refund.TicketId != ticket.TicketId {
+		t.Fatalf("%+v", refund)
+	}
+	l.fail(l.alice, "cancel_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+	l.ok(l.bob, "book_tickets", `{"showId":"S1","seats":["A1"]}`)
+
+	ticket = book("A2")
+	late := book("A3")
+	l.now = time.Date(2019, 12, 29, 4, 0, 0, 0, time.UTC)
+	if refund = cancel(ticket); refund.RefundPercent != 50 || refund.RefundAmount != ticket.TotalPrice/2 {
+		t.Fatalf("%+v", refund)
+	}
+	l.now = time.Date(2019, 12, 29, 8, 0, 0, 0, time.UTC)
+	if refund = cancel(late); refund.RefundPercent != 0 || refund.RefundAmount != 0 {
+		t.Fatalf("%+v", refund)
+	}
+
+	// the policy is set by platform admins
+	l.fail(l.theatreAdmin, "set_refund_policy", `{"tiers":[{"hoursBeforeShow":0,"refundPercent":100}]}`)
+	l.ok(l.admin, "set_refund_policy", `{"tiers":[{"hoursBeforeShow":0,"refundPercent":100}]}`)
+	if refund = cancel(book("A4")); refund.RefundPercent != 100 {
+		t.Fatalf("%+v", refund)
+	}
+	ticket = book("A2")
+	l.now = time.Date(2019, 12, 29, 10, 0, 0, 0, time.UTC)
+	l.fail(l.alice, "cancel_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+}
@@ -0,0 +1,379 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Screen Struct - an auditorium of a theatre with its seat layout and what it can play
+type Screen struct {
+	ObjectType   string `json:"docType"` // field defined for couchdb
+	TheatreRegNo string `json:"theatreRegNo"`
+	ScreenLayout
+	ScreenName string   `json:"screenName"`
+	Capacity   int      `json:"capacity"`
+	Formats    []string `json:"formats"`  // movie formats the screen can play, 2D, 3D, IMAX
+	Features   []string `json:"features"` // e.g. Dolby Atmos, Recliners
+}
+
+// Whether the screen can play a movie of the given format. Screens that
+// list no formats were set up before screens had formats and play any movie.
+func (screen Screen) supports(format string) bool {
+	if len(screen.Formats) == 0 {
+		return true
+	}
+	for _, f := range screen.Formats {
+		if strings.EqualFold(f, format) {
+			return true
+		}
+	}
+	return false
+}
+
+// Screen of a theatre without a Screen record, from the theatre layouts or the default layout of the config
+func legacyScreen(theatre Theatre, screenNumber int, cfg Config) Screen {
+	var screen Screen
+	screen.ObjectType = "Screen"
+	screen.TheatreRegNo = theatre.TheatreRegNo
+	screen.ScreenNumber = screenNumber
+	screen.Rows = cfg.DefaultSeatRows
+	screen.Columns = cfg.DefaultSeatColumns
+	for _, layout := range theatre.ScreenLayouts {
+		if layout.ScreenNumber == screenNumber && layout.Rows > 0 && layout.Columns > 0 {
+			screen.ScreenLayout = layout
+		}
+	}
+	screen.ScreenName = "Screen " + strconv.Itoa(screenNumber)
+	screen.Capacity = screen.Rows * screen.Columns
+	return screen
+}
+
+// Reads the screens of a theatre ordered by screen number. Screens 1..NumberOfScreens
+// without a Screen record keep the layout they had before screens were recorded.
+func getTheatreScreens(stub shim.ChaincodeStubInterface, theatre Theatre, cfg Config) ([]Screen, error) {
+	screensAsBytes, err := getEntitiesByPartialKey(stub, keyScreen, theatre.TheatreRegNo)
+	if err != nil {
+		return nil, err
+	}
+	recorded := make(map[int]Screen)
+	for _, screenAsBytes := range screensAsBytes {
+		screen := Screen{}
+		json.Unmarshal(screenAsBytes, &screen)
+		recorded[screen.ScreenNumber] = screen
+	}
+	for i := 1; i <= theatre.NumberOfScreens; i++ {
+		if _, ok := recorded[i]; !ok {
+			recorded[i] = legacyScreen(theatre, i, cfg)
+		}
+	}
+
+	var screens []Screen
+	for _, screen := range recorded {
+		screens = append(screens, screen)
+	}
+	sort.Slice(screens, func(i, j int) bool {
+		return screens[i].ScreenNumber < screens[j].ScreenNumber
+	})
+	return screens, nil
+}
+
+// Reads one screen of a theatre
+func getScreen(stub shim.ChaincodeStubInterface, theatre Theatre, screenNumber int, cfg Config) (Screen, error) {
+	screens, err := getTheatreScreens(stub, theatre, cfg)
+	if err != nil {
+		return Screen{}, err
+	}
+	for _, screen := range screens {
+		if screen.ScreenNumber == screenNumber {
+			return screen, nil
+		}
+	}
+	return Screen{}, errors.New("Screen " + strconv.Itoa(screenNumber) + " does not exist in theatre " + theatre.TheatreRegNo)
+}
+
+// Start and end of a show. Shows added before runtimes were recorded are taken
+// to run for the default runtime.
+func showTimes(show Shows, cfg Config) (time.Time, time.Time, error) {
+	start, err := showStartTime(show.ShowStart, show.ShowTiming)
+	if err != nil {
+		return start, start, err
+	}
+	if show.ShowEnd == "" {
+		return start, start.Add(time.Duration(cfg.DefaultRuntimeMinutes) * time.Minute), nil
+	}
+	end, err := time.Parse(time.RFC3339, show.ShowEnd)
+	return start, end, err
+}
+
+// Time a show keeps its screen, from its start until its end plus the cleaning buffer
+func showOccupancy(show Shows, cfg Config) (time.Time, time.Time, error) {
+	start, end, err := showTimes(show, cfg)
+	return start, end.Add(time.Duration(cfg.CleaningBufferMinutes) * time.Minute), err
+}
+
+// Reads the shows of a theatre on the day of start and on the two days either side of it. Runtime
+// and cleaning buffer are each at most 1440 minutes, so a show keeps its screen for at most two
+// days and only shows of these days can overlap one of the day.
+func getShowsAround(stub shim.ChaincodeStubInterface, theatreRegNo string, start time.Time) ([]Shows, error) {
+	var shows []Shows
+	for day := -2; day <= 2; day++ {
+		dayShows, err := getTheatreShows(stub, theatreRegNo, start.AddDate(0, 0, day).Format("2006-01-02"))
+		if err != nil {
+			return nil, err
+		}
+		shows = append(shows, dayShows...)
+	}
+	return shows, nil
+}
+
+// ============================================================================================================================
+// add_screen() - add a screen to the caller's theatre, or replace the details of an existing screen
+//
+// Shows already added keep the seat map they were created with.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"screenNumber":1,"screenName":"Audi 1","rows":8,"columns":12,"rowCategories":{"H":"Recliner"},
+//   "formats":["2D","3D"],"features":["Dolby Atmos","Recliners"]}
+// ============================================================================================================================
+func add_screen(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting add_screen")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	theatreAsBytes, _ := getEntity(stub, keyTheatre, caller.TheatreRegNo)
+	if theatreAsBytes == nil {
+		return shim.Error("This theatre does not exists - " + caller.TheatreRegNo)
+	}
+	theatre := Theatre{}
+	json.Unmarshal(theatreAsBytes, &theatre)
+
+	var screen Screen
+	err = json.Unmarshal([]byte(args[0]), &screen)
+	if err != nil {
+		return shim.Error("Invalid screen : " + err.Error())
+	}
+	if screen.ScreenNumber < 1 {
+		return shim.Error("screenNumber must be at least 1")
+	}
+	if screen.Rows < 1 || screen.Columns < 1 {
+		return shim.Error("A screen needs at least 1 row and 1 column")
+	}
+	if len(screen.Formats) == 0 {
+		screen.Formats = []string{"2D"}
+	}
+	if screen.ScreenName == "" {
+		screen.ScreenName = "Screen " + strconv.Itoa(screen.ScreenNumber)
+	}
+	screen.ObjectType = "Screen"
+	screen.TheatreRegNo = theatre.TheatreRegNo
+	screen.Capacity = screen.Rows * screen.Columns
+
+	errPut := putEntity(stub, screen, keyScreen, screen.TheatreRegNo, strconv.Itoa(screen.ScreenNumber)) // write the screen details into the ledger
+	if errPut != nil {
+		return shim.Error("Failed to add screen : " + errPut.Error())
+	}
+
+	// screens are numbered 1..NumberOfScreens
+	if screen.ScreenNumber > theatre.NumberOfScreens {
+		theatre.NumberOfScreens = screen.ScreenNumber
+		errTr := putEntity(stub, theatre, keyTheatre, theatre.TheatreRegNo)
+		if errTr != nil {
+			return shim.Error("Failed to add screen : " + errTr.Error())
+		}
+	}
+
+	screenAsBytes, _ := json.Marshal(screen)
+	fmt.Println("- end add_screen")
+	return shim.Success(screenAsBytes)
+}
+
+// ============================================================================================================================
+// get_theatre_screens() - read all screens of a theatre
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"theatreRegNo":"value1"}
+// ============================================================================================================================
+func get_theatre_screens(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_theatre_screens")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	theatreRegNo, _ := jsonValue["theatreRegNo"].(string)
+
+	theatreAsBytes, _ := getEntity(stub, keyTheatre, theatreRegNo)
+	if theatreAsBytes == nil {
+		return shim.Error("This theatre does not exists - " + theatreRegNo)
+	}
+	theatre := Theatre{}
+	json.Unmarshal(theatreAsBytes, &theatre)
+
+	cfg, err := getConfig(stub, theatreRegNo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	screens, err := getTheatreScreens(stub, theatre, cfg)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	screensAsBytes, _ := json.Marshal(screens)
+
+	fmt.Println("- end get_theatre_screens")
+	return shim.Success(screensAsBytes)
+}
+
+// ScreenUtilization Struct - use of one screen over one day
+type ScreenUtilization struct {
+	ScreenNumber       int      `json:"screenNumber"`
+	ScreenName         string   `json:"screenName"`
+	Shows              []string `json:"shows"`
+	ShowMinutes        int      `json:"showMinutes"`        // minutes of the day movies are playing
+	UtilizationPercent int      `json:"utilizationPercent"` // showMinutes out of the 1440 minutes of the day
+	TotalSeats         int      `json:"totalSeats"`
+	BookedSeats        int      `json:"bookedSeats"`
+	OccupancyPercent   int      `json:"occupancyPercent"` // bookedSeats out of totalSeats
+}
+
+// ============================================================================================================================
+// get_screen_utilization() - read how much each screen of a theatre is used on a day
+//
+// Shows running over midnight count towards both days. Withdrawn shows are left out. Theatre
+// admins read their own theatre, platform admins any theatre.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"theatreRegNo":"value1","showDate":"2019-12-29"}
+// ============================================================================================================================
+func get_screen_utilization(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_screen_utilization")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	theatreRegNo, _ := jsonValue["theatreRegNo"].(string)
+	showDate, _ := jsonValue["showDate"].(string)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	if caller.Role != RolePlatformAdmin && caller.TheatreRegNo != theatreRegNo {
+		return codedError(ErrAccessDenied, "Theatre admins can only read the utilization of "+caller.TheatreRegNo)
+	}
+
+	theatreAsBytes, _ := getEntity(stub, keyTheatre, theatreRegNo)
+	if theatreAsBytes == nil {
+		return shim.Error("This theatre does not exists - " + theatreRegNo)
+	}
+	theatre := Theatre{}
+	json.Unmarshal(theatreAsBytes, &theatre)
+
+	loc, err := theatreLocation(theatre.TimeZone)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dayStart, err := startOfDate(showDate, loc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	cfg, err := getConfig(stub, theatreRegNo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	screens, err := getTheatreScreens(stub, theatre, cfg)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	shows, err := getShowsAround(stub, theatreRegNo, dayStart)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	utilization := []ScreenUtilization{}
+	for _, screen := range screens {
+		var use ScreenUtilization
+		use.ScreenNumber = screen.ScreenNumber
+		use.ScreenName = screen.ScreenName
+		use.Shows = []string{}
+		for _, show := range shows {
+			if show.ScreenNumber != screen.ScreenNumber || show.ShowStatus == ShowCancelled {
+				continue
+			}
+			start, end, err := showTimes(show, cfg)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			// part of the show inside the day
+			if start.Before(dayStart) {
+				start = dayStart
+			}
+			if end.After(dayEnd) {
+				end = dayEnd
+			}
+			if !start.Before(end) {
+				continue
+			}
+			err = countSeats(stub, &show)
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			use.Shows = append(use.Shows, show.ShowId)
+			use.ShowMinutes += int(end.Sub(start).Minutes())
+			use.TotalSeats += show.TotalSeat
+			use.BookedSeats += show.BookedSeat
+		}
+		use.UtilizationPercent = use.ShowMinutes * 100 / (24 * 60)
+		if use.TotalSeats > 0 {
+			use.OccupancyPercent = use.BookedSeats * 100 / use.TotalSeats
+		}
+		utilization = append(utilization, use)
+	}
+	utilizationAsBytes, _ := json.Marshal(utilization)
+
+	fmt.Println("- end get_screen_utilization")
+	return shim.Success(utilizationAsBytes)
+}
@@ -0,0 +1,113 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Screens have their own layout, seat categories and formats, shows go on a screen supporting the movie
+func TestAddScreen(t *testing.T) {
+	l := newTestLedger(t, 3, 3)
+	var screens []Screen
+	json.Unmarshal(l.ok(l.alice, "get_theatre_screens", `{"theatreRegNo":"TH1"}`), &screens)
+	if len(screens) != 1 || screens[0].Capacity != 9 {
+		t.Fatalf("%+v", screens)
+	}
+	l.fail(l.alice, "add_screen", `{"screenNumber":2,"rows":2,"columns":5}`)
+	l.ok(l.theatreAdmin, "add_screen", `{"screenNumber":2,"rows":2,"columns":5,"rowCategories":{"B":"Recliner"},"formats":["3D"]}`)
+	json.Unmarshal(l.ok(l.alice, "get_theatre_screens", `{"theatreRegNo":"TH1"}`), &screens)
+	if len(screens) != 2 || screens[1].Capacity != 10 {
+		t.Fatalf("%+v", screens)
+	}
+
+	// screen 1 is taken at 10am
+	l.ok(l.theatreAdmin, "add_movies", `{"movieId":"M3","movieName":"Deep","format":"3D"}`)
+	l.ok(l.theatreAdmin, "add_shows", `{"showId":"S3","showTiming":"2019-12-29 10:00am","movieId":"M3","docType":"Shows"}`)
+	var show Shows
+	json.Unmarshal(l.ok(l.alice, "get_show", `{"showId":"S3"}`), &show)
+	if show.ScreenNumber != 2 || show.TotalSeat != 10 {
+		t.Fatalf("%+v", show)
+	}
+	var sm SeatMap
+	json.Unmarshal(l.ok(l.alice, "get_seat_map", `{"showId":"S3"}`), &sm)
+	for _, seat := range sm.Seats {
+		if (seat.Row == "B") != (seat.Category == "Recliner") {
+			t.Fatalf("%+v", seat)
+		}
+	}
+
+	// screens without formats show any movie
+	l.ok(l.theatreAdmin, "add_screen", `{"screenNumber":1,"rows":3,"columns":3,"formats":["2D"]}`)
+	l.ok(l.theatreAdmin, "add_movies", `{"movieId":"M4","movieName":"Huge","format":"IMAX"}`)
+	l.fail(l.theatreAdmin, "add_shows", `{"showId":"S4","showTiming":"2019-12-30 10:00am","movieId":"M4","docType":"Shows"}`)
+}
+
+// A show keeping its screen for two days clashes with the shows of the day after next
+func TestLongShowKeepsItsScreen(t *testing.T) {
+	l := newTestLedger(t, 10, 10)
+	l.ok(l.admin, "set_config", `{"config":{"cleaningBufferMinutes":1440}}`)
+	l.ok(l.theatreAdmin, "add_movies", `{"movieId":"M2","movieName":"Marathon","runtimeMinutes":1440}`)
+	l.ok(l.theatreAdmin, "add_shows", `{"showId":"S2","showTiming":"2019-12-23 09:00am","movieId":"M2","docType":"Shows"}`)
+
+	l.fail(l.theatreAdmin, "add_shows", `{"showId":"S3","showTiming":"2019-12-25 08:00am","movieId":"M1","docType":"Shows"}`)
+	l.ok(l.theatreAdmin, "add_shows", `{"showId":"S3","showTiming":"2019-12-25 09:00am","movieId":"M1","docType":"Shows"}`)
+}
+
+// A movie runs on every free screen at once, the utilization counts each screen on its own
+func TestMovieOnSeveralScreens(t *testing.T) {
+	l := newTestLedger(t, 10, 10)
+	l.ok(l.theatreAdmin, "add_screen", `{"screenNumber":2,"rows":10,"columns":10}`)
+	l.ok(l.theatreAdmin, "add_screen", `{"screenNumber":3,"rows":10,"columns":10}`)
+	l.ok(l.theatreAdmin, "add_shows", `{"showId":"S2","showTiming":"2019-12-29 10:00am","movieId":"M1","docType":"Shows"}`)
+	l.ok(l.theatreAdmin, "add_shows", `{"showId":"S3","showTiming":"2019-12-29 10:30am","movieId":"M1","docType":"Shows"}`)
+	l.fail(l.theatreAdmin, "add_shows", `{"showId":"S4","showTiming":"2019-12-29 10:30am","movieId":"M1","docType":"Shows"}`)
+
+	l.ok(l.theatreAdmin, "add_movies", `{"movieId":"M2","movieName":"Short","runtimeMinutes":90}`)
+	l.fail(l.theatreAdmin, "add_shows", `{"showId":"S5","showTiming":"2019-12-29 01:00pm","movieId":"M2","screenNumber":1,"docType":"Shows"}`)
+	l.ok(l.theatreAdmin, "add_shows", `{"showId":"S5","showTiming":"2019-12-29 11:00pm","movieId":"M2","screenNumber":2,"docType":"Shows"}`)
+	var show Shows
+	json.Unmarshal(l.ok(l.alice, "get_show", `{"showId":"S5"}`), &show)
+	if show.ScreenNumber != 2 {
+		t.Fatalf("%+v", show)
+	}
+
+	l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1","A2"]}`)
+	var screens []ScreenUtilization
+	json.Unmarshal(l.ok(l.theatreAdmin, "get_screen_utilization", `{"theatreRegNo":"TH1","showDate":"2019-12-29"}`), &screens)
+	if len(screens) != 3 || screens[0].ShowMinutes != 180 || screens[1].ShowMinutes != 240 || screens[0].BookedSeats != 2 {
+		t.Fatalf("%+v", screens)
+	}
+	json.Unmarshal(l.ok(l.theatreAdmin, "get_screen_utilization", `{"theatreRegNo":"TH1","showDate":"2019-12-30"}`), &screens)
+	if screens[1].ShowMinutes != 30 {
+		t.Fatalf("%+v", screens)
+	}
+}
+
+// Theatre admins read the utilization of their own theatre only
+func TestUtilizationOfOtherTheatre(t *testing.T) {
+	l := newTestLedger(t, 10, 10)
+	l.ok(l.admin, "add_theatre", `{"theatreRegNo":"TH2","theatreName":"Other","numberOfScreens":1,"docType":"Theatre"}`)
+	other := benchIdentity("Org1MSP", "TH2", map[string]string{"role": "theatreAdmin", "theatreRegNo": "TH2"})
+
+	query := `{"theatreRegNo":"TH1","showDate":"2019-12-29"}`
+	l.fail(other, "get_screen_utilization", query)
+	l.ok(l.theatreAdmin, "get_screen_utilization", query)
+	l.ok(l.admin, "get_screen_utilization", query)
+}
@@ -0,0 +1,389 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Seat states kept on the seat map of a show
+const (
+	SeatFree = "Free"
+	SeatHeld = "Held"
+	SeatSold = "Sold"
+)
+
+// Seat category of rows the layout does not name
+const (
+	defaultSeatCategory = "Standard"
+)
+
+// ScreenLayout Struct
+type ScreenLayout struct {
+	ScreenNumber  int               `json:"screenNumber"`
+	Rows          int               `json:"rows"`
+	Columns       int               `json:"columns"`
+	RowCategories map[string]string `json:"rowCategories"` // seat category by row label, e.g. {"A":"Recliner"}
+}
+
+// SeatMap Struct
+type SeatMap struct {
+	ObjectType string `json:"docType"` // field defined for couchdb
+	ShowId     string `json:"showId"`
+	Rows       int    `json:"rows"`
+	Columns    int    `json:"columns"`
+	Seats      []Seat `json:"seats"`
+}
+
+// Seat Struct
+type Seat struct {
+	SeatId     string `json:"seatId"`
+	Row        string `json:"row"`
+	Column     int    `json:"column"`
+	Category   string `json:"category"`
+	Status     string `json:"status"`
+	TicketId   string `json:"ticketId"`
+	HoldId     string `json:"holdId"`
+	HeldUntil  string `json:"heldUntil"` // a held seat is free again from this time
+	AdmittedAt string `json:"admittedAt"`
+}
+
+// Row label for a zero based row index - A..Z, then AA, AB ...
+func rowLabel(row int) string {
+	label := ""
+	for row >= 0 {
+		label = string(rune('A'+row%26)) + label
+		row = row/26 - 1
+	}
+	return label
+}
+
+// Builds an empty seat map for a show, every seat is free
+func newSeatMap(showId string, layout ScreenLayout) SeatMap {
+	var sm SeatMap
+	sm.ObjectType = "SeatMap"
+	sm.ShowId = showId
+	sm.Rows = layout.Rows
+	sm.Columns = layout.Columns
+	for r := 0; r < layout.Rows; r++ {
+		for c := 1; c <= layout.Columns; c++ {
+			var seat Seat
+			seat.Row = rowLabel(r)
+			seat.Column = c
+			seat.SeatId = seat.Row + strconv.Itoa(c)
+			seat.Category = layout.RowCategories[seat.Row]
+			if seat.Category == "" {
+				seat.Category = defaultSeatCategory
+			}
+			seat.Status = SeatFree
+			sm.Seats = append(sm.Seats, seat)
+		}
+	}
+	return sm
+}
+
+// Reads the layout of the seats of a show. Seats keep the state they had when the layout was
+// written, booked and held seats are on their own Seat keys.
+func getSeatLayout(stub shim.ChaincodeStubInterface, showId string) (SeatMap, error) {
+	var sm SeatMap
+	smAsBytes, err := getEntity(stub, keySeatMap, showId)
+	if err != nil {
+		return sm, err
+	}
+	if smAsBytes == nil {
+		return sm, errors.New("Seat map does not exist for show - " + showId)
+	}
+	err = json.Unmarshal(smAsBytes, &sm)
+	return sm, err
+}
+
+// Reads the live seat map of a show, the layout with the state of every seat written since.
+// The range read conflicts with every booking of the show, so only queries and admin
+// transactions use it - bookings read their own seats with getSeats.
+func getSeatMap(stub shim.ChaincodeStubInterface, showId string) (SeatMap, error) {
+	sm, err := getSeatLayout(stub, showId)
+	if err != nil {
+		return sm, err
+	}
+	index := make(map[string]int)
+	for i, seat := range sm.Seats {
+		index[seat.SeatId] = i
+	}
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(keySeat, []string{showId})
+	if err != nil {
+		return sm, err
+	}
+	defer resultsIterator.Close()
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			return sm, err
+		}
+		var seat Seat
+		json.Unmarshal(queryResponse.Value, &seat)
+		if i, ok := index[seat.SeatId]; ok {
+			sm.Seats[i] = seat
+		}
+	}
+	return sm, nil
+}
+
+// Reads only the requested seats of a show, each from its own key. Bookings of other seats of
+// the show write other keys, so they do not conflict. Unknown seats are left out.
+func getSeats(stub shim.ChaincodeStubInterface, showId string, seatIds []string) (SeatMap, error) {
+	layout, err := getSeatLayout(stub, showId)
+	if err != nil {
+		return layout, err
+	}
+	sm := layout
+	sm.Seats = nil
+	read := make(map[string]bool)
+	for _, seatId := range seatIds {
+		if read[seatId] {
+			continue
+		}
+		read[seatId] = true
+		for _, seat := range layout.Seats {
+			if seat.SeatId != seatId {
+				continue
+			}
+			seatAsBytes, err := getEntity(stub, keySeat, showId, seatId)
+			if err != nil {
+				return sm, err
+			}
+			if seatAsBytes != nil {
+				json.Unmarshal(seatAsBytes, &seat)
+			}
+			sm.Seats = append(sm.Seats, seat)
+		}
+	}
+	return sm, nil
+}
+
+// Writes the layout of the seats of a show into the ledger
+func putSeatMap(stub shim.ChaincodeStubInterface, sm SeatMap) error {
+	return putEntity(stub, sm, keySeatMap, sm.ShowId)
+}
+
+// Writes each seat of the seat map to its own key
+func putSeats(stub shim.ChaincodeStubInterface, sm SeatMap) error {
+	for _, seat := range sm.Seats {
+		err := putEntity(stub, seat, keySeat, sm.ShowId, seat.SeatId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replaces the seats of a show - writes the layout with every seat free, then a Seat key for
+// each seat which is not free, and deletes the Seat keys left from before
+func resetSeatMap(stub shim.ChaincodeStubInterface, sm SeatMap) error {
+	resultsIterator, err := stub.GetStateByPartialCompositeKey(keySeat, []string{sm.ShowId})
+	if err != nil {
+		return err
+	}
+	var oldKeys []string
+	for resultsIterator.HasNext() {
+		queryResponse, err := resultsIterator.Next()
+		if err != nil {
+			resultsIterator.Close()
+			return err
+		}
+		oldKeys = append(oldKeys, queryResponse.Key)
+	}
+	resultsIterator.Close()
+	for _, key := range oldKeys {
+		err = stub.DelState(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	layout := sm
+	layout.Seats = nil
+	taken := sm
+	taken.Seats = nil
+	for _, seat := range sm.Seats {
+		if seat.Status != SeatFree {
+			taken.Seats = append(taken.Seats, seat)
+		}
+		seat.Status = SeatFree
+		seat.TicketId = ""
+		seat.HoldId = ""
+		seat.HeldUntil = ""
+		seat.AdmittedAt = ""
+		layout.Seats = append(layout.Seats, seat)
+	}
+	err = putSeatMap(stub, layout)
+	if err != nil {
+		return err
+	}
+	return putSeats(stub, taken)
+}
+
+// Number of seats sold on a seat map
+func bookedSeats(sm SeatMap) int {
+	booked := 0
+	for _, seat := range sm.Seats {
+		if seat.Status == SeatSold {
+			booked++
+		}
+	}
+	return booked
+}
+
+// Tells if a held seat is past the expiry of its hold
+func holdExpired(seat Seat, now time.Time) bool {
+	until, err := time.Parse(time.RFC3339, seat.HeldUntil)
+	return err != nil || !now.Before(until)
+}
+
+// Frees the seats whose hold has expired. Nothing sweeps expired holds, a seat keeps its
+// expired hold until it is next booked or held, which findFreeSeats allows.
+func clearExpiredHolds(sm *SeatMap, now time.Time) int {
+	cleared := 0
+	for i, seat := range sm.Seats {
+		if seat.Status == SeatHeld && holdExpired(seat, now) {
+			sm.Seats[i].Status = SeatFree
+			sm.Seats[i].HoldId = ""
+			sm.Seats[i].HeldUntil = ""
+			cleared++
+		}
+	}
+	return cleared
+}
+
+// Checks the requested seats can be taken and gives their position on the seat map.
+// Seats held by holdId count as free, other holds only once they have expired.
+func findFreeSeats(sm *SeatMap, seatIds []string, holdId string, now time.Time) ([]int, error) {
+	if len(seatIds) == 0 {
+		return nil, errors.New("At least one seat must be selected")
+	}
+	index := make(map[string]int)
+	for i, seat := range sm.Seats {
+		index[seat.SeatId] = i
+	}
+	var found []int
+	requested := make(map[string]bool)
+	for _, seatId := range seatIds {
+		i, ok := index[seatId]
+		if !ok {
+			return nil, errors.New("Seat " + seatId + " does not exist for show " + sm.ShowId)
+		}
+		if requested[seatId] {
+			return nil, errors.New("Seat " + seatId + " is selected more than once")
+		}
+		seat := sm.Seats[i]
+		switch {
+		case seat.Status == SeatFree:
+		case seat.Status == SeatHeld && holdId != "" && seat.HoldId == holdId:
+		case seat.Status == SeatHeld && holdExpired(seat, now):
+		default:
+			return nil, errors.New("Seat " + seatId + " is not available")
+		}
+		requested[seatId] = true
+		found = append(found, i)
+	}
+	return found, nil
+}
+
+// Marks the requested seats as sold to a ticket. Fails without touching the
+// seat map if any seat is unknown, repeated or not free.
+func sellSeats(sm *SeatMap, seatIds []string, ticketId string, holdId string, now time.Time) error {
+	found, err := findFreeSeats(sm, seatIds, holdId, now)
+	if err != nil {
+		return err
+	}
+	for _, i := range found {
+		sm.Seats[i].Status = SeatSold
+		sm.Seats[i].TicketId = ticketId
+		sm.Seats[i].HoldId = ""
+		sm.Seats[i].HeldUntil = ""
+	}
+	return nil
+}
+
+// Marks the requested seats as held until the expiry of the hold
+func holdSeats(sm *SeatMap, seatIds []string, holdId string, until time.Time, now time.Time) error {
+	found, err := findFreeSeats(sm, seatIds, "", now)
+	if err != nil {
+		return err
+	}
+	for _, i := range found {
+		sm.Seats[i].Status = SeatHeld
+		sm.Seats[i].HoldId = holdId
+		sm.Seats[i].HeldUntil = until.Format(time.RFC3339)
+	}
+	return nil
+}
+
+// Frees the seats still held by a hold
+func releaseHeldSeats(sm *SeatMap, holdId string) int {
+	released := 0
+	for i, seat := range sm.Seats {
+		if seat.Status == SeatHeld && seat.HoldId == holdId {
+			sm.Seats[i].Status = SeatFree
+			sm.Seats[i].HoldId = ""
+			sm.Seats[i].HeldUntil = ""
+			released++
+		}
+	}
+	return released
+}
+
+// ============================================================================================================================
+// get_seat_map() - read the live seat map of a show
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"showId":"value1"}
+// ============================================================================================================================
+func get_seat_map(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_seat_map")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	showId, _ := jsonValue["showId"].(string)
+
+	sm, err := getSeatMap(stub, showId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	clearExpiredHolds(&sm, now) // only on the copy read, the query writes nothing
+	smAsBytes, _ := json.Marshal(sm)
+
+	fmt.Println("- end get_seat_map")
+	return shim.Success(smAsBytes)
+}
@@ -0,0 +1,54 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// Seats are booked one by one, a seat sold once cannot be booked again
+func TestBookSeats(t *testing.T) {
+	l := newTestLedger(t, 2, 3)
+	var ticket Tickets
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1","B3"]}`), &ticket)
+	if ticket.NumberOfTickets != 2 || len(ticket.Amenities) != 2 {
+		t.Fatalf("%+v", ticket)
+	}
+	l.fail(l.bob, "book_tickets", `{"showId":"S1","seats":["A2","A1"]}`)
+	l.fail(l.bob, "book_tickets", `{"showId":"S1","seats":["Z9"]}`)
+	l.ok(l.bob, "book_tickets", `{"showId":"S1","seats":["A2"]}`)
+
+	var sm SeatMap
+	json.Unmarshal(l.ok(l.alice, "get_seat_map", `{"showId":"S1"}`), &sm)
+	sold := make(map[string]string)
+	for _, seat := range sm.Seats {
+		if seat.Status != SeatFree {
+			sold[seat.SeatId] = seat.TicketId
+		}
+	}
+	if len(sm.Seats) != 6 || len(sold) != 3 || sold["A1"] != ticket.TicketId || sold["B3"] != ticket.TicketId {
+		t.Fatalf("%+v", sm)
+	}
+
+	var show Shows
+	json.Unmarshal(l.ok(l.alice, "get_show", `{"showId":"S1"}`), &show)
+	if show.TotalSeat != 6 || show.BookedSeat != 3 || show.AvailableSeat != 3 {
+		t.Fatalf("%+v", show)
+	}
+}
@@ -0,0 +1,485 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Show states. HouseFull and Completed follow from the seats left and the
+// time of the transaction, Cancelled is set by cancel_show.
+const (
+	ShowRunning   = "Running"
+	ShowHouseFull = "HouseFull"
+	ShowCompleted = "Completed"
+	ShowCancelled = "Cancelled"
+)
+
+// Chaincode events sent to ticket holders
+const (
+	EventShowRescheduled = "ShowRescheduled"
+	EventShowCancelled   = "ShowCancelled"
+)
+
+// ShowChange Struct - payload of the events sent when a show is rescheduled or cancelled
+type ShowChange struct {
+	ShowId          string         `json:"showId"`
+	TheatreRegNo    string         `json:"theatreRegNo"`
+	ShowStatus      string         `json:"showStatus"`
+	OldShowStart    string         `json:"oldShowStart"`
+	NewShowStart    string         `json:"newShowStart"`
+	OldScreenNumber int            `json:"oldScreenNumber"`
+	NewScreenNumber int            `json:"newScreenNumber"`
+	Reason          string         `json:"reason"`
+	Tickets         []TicketHolder `json:"tickets"`
+	RefundJobId     string         `json:"refundJobId"` // job refunding the tickets of a cancelled show
+}
+
+// TicketHolder Struct - ticket affected by a show change and its owner
+type TicketHolder struct {
+	TicketId     string `json:"ticketId"`
+	Owner        string `json:"owner"`
+	RefundAmount int    `json:"refundAmount"`
+}
+
+// Status of a show at the time given
+func showStatus(show Shows, now time.Time, cfg Config) string {
+	if show.ShowStatus == ShowCancelled {
+		return ShowCancelled
+	}
+	if _, end, err := showTimes(show, cfg); err == nil && !now.Before(end) {
+		return ShowCompleted
+	}
+	if show.TotalSeat > 0 && show.AvailableSeat <= 0 {
+		return ShowHouseFull
+	}
+	return ShowRunning
+}
+
+// Sets HouseFull or Running on a show from its seats left, cancelled shows stay cancelled
+func updateSeatStatus(show *Shows) {
+	if show.ShowStatus == ShowCancelled || show.ShowStatus == ShowCompleted {
+		return
+	}
+	if show.AvailableSeat <= 0 {
+		show.ShowStatus = ShowHouseFull
+	} else {
+		show.ShowStatus = ShowRunning
+	}
+}
+
+// Counts the seats booked and left of a show from its seats and sets HouseFull or Running.
+// The counters kept on the Shows document are not updated by bookings.
+func countSeats(stub shim.ChaincodeStubInterface, show *Shows) error {
+	smAsBytes, err := getEntity(stub, keySeatMap, show.ShowId)
+	if err != nil || smAsBytes == nil {
+		return err // shows older than seat maps keep their counters
+	}
+	sm, err := getSeatMap(stub, show.ShowId)
+	if err != nil {
+		return err
+	}
+	show.BookedSeat = bookedSeats(sm)
+	show.AvailableSeat = show.TotalSeat - show.BookedSeat
+	updateSeatStatus(show)
+	return nil
+}
+
+// Reads the booked tickets of a show through the show~ticket index
+func getShowTickets(stub shim.ChaincodeStubInterface, showId string) ([]Tickets, error) {
+	entries, err := getIndex(stub, indexShowTicket, showId)
+	if err != nil {
+		return nil, err
+	}
+	var tickets []Tickets
+	for _, entry := range entries {
+		tktAsBytes, err := getEntity(stub, keyTicket, entry[1])
+		if err != nil {
+			return nil, err
+		}
+		if tktAsBytes == nil {
+			continue
+		}
+		ticket := Tickets{}
+		json.Unmarshal(tktAsBytes, &ticket)
+		if ticket.Status == TicketBooked {
+			tickets = append(tickets, ticket)
+		}
+	}
+	return tickets, nil
+}
+
+// Writes the schedule of a show, and Cancelled, on top of its stored document. The seat counters
+// and the HouseFull or Running status are counted from the seats when read, they are not written.
+func putShow(stub shim.ChaincodeStubInterface, show Shows) error {
+	stored, err := getShow(stub, show.ShowId)
+	if err != nil {
+		return err
+	}
+	stored.ShowTiming = show.ShowTiming
+	stored.ShowStart = show.ShowStart
+	stored.ShowEnd = show.ShowEnd
+	stored.ShowDate = show.ShowDate
+	stored.ScreenNumber = show.ScreenNumber
+	stored.ScreenName = show.ScreenName
+	stored.TotalSeat = show.TotalSeat
+	if show.ShowStatus == ShowCancelled {
+		stored.ShowStatus = ShowCancelled
+	}
+	return putEntity(stub, stored, keyShow, show.ShowId)
+}
+
+// Reads a show of the caller's theatre that can still be changed - not cancelled, not started yet
+func getChangeableShow(stub shim.ChaincodeStubInterface, showId string, now time.Time) (Shows, error) {
+	var show Shows
+	caller, err := get_caller(stub)
+	if err != nil {
+		return show, errors.New("Error retrieving cert")
+	}
+	shAsBytes, _ := getEntity(stub, keyShow, showId)
+	if shAsBytes == nil {
+		return show, errors.New("This show does not exists - " + showId)
+	}
+	json.Unmarshal(shAsBytes, &show)
+	if show.TheatreRegNo != caller.TheatreRegNo {
+		return show, errors.New("Only " + show.TheatreRegNo + " can change show " + showId)
+	}
+	if show.ShowStatus == ShowCancelled {
+		return show, errors.New("This show has been cancelled - " + showId)
+	}
+	start, err := showStartTime(show.ShowStart, show.ShowTiming)
+	if err != nil {
+		return show, err
+	}
+	if !now.Before(start) {
+		return show, errors.New("Shows cannot be changed once they have started - " + showId)
+	}
+	err = countSeats(stub, &show)
+	return show, err
+}
+
+// ============================================================================================================================
+// reschedule_show() - move a show of the caller's theatre to another time and/or screen
+//
+// The new slot goes through the same checks as add_shows. Seats sold keep their seat numbers, so a show
+// can only move to a screen which has all of them. Ticket holders are told through the ShowRescheduled event.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"showId":"value1","showTiming":"2019-12-29 01:00pm","screenNumber":2,"reason":"value2"}
+// ============================================================================================================================
+func reschedule_show(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting reschedule_show")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	var request struct {
+		ShowId       string `json:"showId"`
+		ShowTiming   string `json:"showTiming"`
+		ScreenNumber int    `json:"screenNumber"`
+		Reason       string `json:"reason"`
+	}
+	json.Unmarshal([]byte(args[0]), &request)
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to reschedule show : " + err.Error())
+	}
+	show, err := getChangeableShow(stub, request.ShowId, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if request.ShowTiming == "" && request.ScreenNumber == 0 {
+		return shim.Error("Expecting a new showTiming or screenNumber for show " + show.ShowId)
+	}
+
+	theatreAsBytes, _ := getEntity(stub, keyTheatre, show.TheatreRegNo)
+	ttr := Theatre{}
+	json.Unmarshal(theatreAsBytes, &ttr)
+	loc, err := theatreLocation(ttr.TimeZone)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	movieAsBytes, _ := getEntity(stub, keyMovie, show.TheatreRegNo, show.MovieId)
+	mov := Movies{}
+	json.Unmarshal(movieAsBytes, &mov)
+	cfg, err := getConfig(stub, show.TheatreRegNo)
+	if err != nil {
+		return shim.Error("Failed to reschedule show : " + err.Error())
+	}
+
+	var change ShowChange
+	change.ShowId = show.ShowId
+	change.TheatreRegNo = show.TheatreRegNo
+	change.OldShowStart = show.ShowStart
+	change.OldScreenNumber = show.ScreenNumber
+	change.Reason = request.Reason
+	oldDate := show.ShowDate
+	oldStart, end, err := showTimes(show, cfg)
+	if err != nil {
+		return shim.Error("Failed to reschedule show : " + err.Error())
+	}
+
+	// new slot of the show
+	start := oldStart
+	if request.ShowTiming != "" {
+		start, err = parseShowTiming(request.ShowTiming, loc)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if !start.After(now) {
+			return shim.Error("Shows cannot be moved into the past - " + request.ShowTiming)
+		}
+		err = checkRunWindow(mov, start, loc)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		show.ShowTiming = request.ShowTiming
+	}
+	start = start.In(loc)
+	show.ShowStart = start.Format(time.RFC3339)
+	show.ShowEnd = start.Add(end.Sub(oldStart)).Format(time.RFC3339)
+	show.ShowDate = start.Format("2006-01-02")
+	show.ScreenNumber = request.ScreenNumber
+
+	screens, err := getTheatreScreens(stub, ttr, cfg)
+	if err != nil {
+		return shim.Error("Failed to reschedule show : " + err.Error())
+	}
+	if request.ScreenNumber == 0 && request.ShowTiming != "" {
+		// stay on the same screen when it is free at the new time
+		show.ScreenNumber = change.OldScreenNumber
+		if _, err := screenAvailable(screens, show, mov, cfg, stub); err != nil {
+			show.ScreenNumber = 0
+		}
+	}
+	screen, err := screenAvailable(screens, show, mov, cfg, stub)
+	if err != nil {
+		fmt.Println(err.Error())
+		return shim.Error(err.Error())
+	}
+	show.ScreenNumber = screen.ScreenNumber
+	show.ScreenName = screen.ScreenName
+
+	// seats sold move to the same seat numbers on the new screen
+	if show.ScreenNumber != change.OldScreenNumber {
+		sm, err := getSeatMap(stub, show.ShowId)
+		if err != nil {
+			return shim.Error("Failed to reschedule show : " + err.Error())
+		}
+		clearExpiredHolds(&sm, now)
+		newSm := newSeatMap(show.ShowId, screen.ScreenLayout)
+		for _, seat := range sm.Seats {
+			if seat.Status == SeatFree {
+				continue
+			}
+			found := false
+			for i := range newSm.Seats {
+				if newSm.Seats[i].SeatId == seat.SeatId {
+					newSm.Seats[i].Status = seat.Status
+					newSm.Seats[i].TicketId = seat.TicketId
+					newSm.Seats[i].HoldId = seat.HoldId
+					newSm.Seats[i].HeldUntil = seat.HeldUntil
+					newSm.Seats[i].AdmittedAt = seat.AdmittedAt
+					found = true
+				}
+			}
+			if !found {
+				return shim.Error("Screen " + screen.ScreenName + " has no seat " + seat.SeatId + " which is " + strings.ToLower(seat.Status) + " for show " + show.ShowId)
+			}
+		}
+		show.TotalSeat = screen.Rows * screen.Columns
+		show.AvailableSeat = show.TotalSeat - show.BookedSeat
+		updateSeatStatus(&show)
+		errSm := resetSeatMap(stub, newSm)
+		if errSm != nil {
+			return shim.Error("Failed to reschedule show : " + errSm.Error())
+		}
+	}
+
+	errShw := putShow(stub, show)
+	if errShw != nil {
+		return shim.Error("Failed to reschedule show : " + errShw.Error())
+	}
+	if show.ShowDate != oldDate {
+		errIdx := delIndex(stub, indexTheatreShow, show.TheatreRegNo, oldDate, show.ShowId)
+		if errIdx == nil {
+			errIdx = putIndex(stub, indexTheatreShow, show.TheatreRegNo, show.ShowDate, show.ShowId)
+		}
+		if errIdx != nil {
+			return shim.Error("Failed to reschedule show : " + errIdx.Error())
+		}
+	}
+
+	// tickets follow the show
+	tickets, err := getShowTickets(stub, show.ShowId)
+	if err != nil {
+		return shim.Error("Failed to reschedule show : " + err.Error())
+	}
+	for _, ticket := range tickets {
+		errIdx := delIndex(stub, indexOwnerTicket, ticket.Owner, oldStart.UTC().Format(time.RFC3339), ticket.TicketId)
+		if errIdx == nil {
+			errIdx = putIndex(stub, indexOwnerTicket, ticket.Owner, start.UTC().Format(time.RFC3339), ticket.TicketId)
+		}
+		if errIdx != nil {
+			return shim.Error("Failed to reschedule show : " + errIdx.Error())
+		}
+		ticket.ShowTiming = show.ShowTiming
+		ticket.ShowStart = show.ShowStart
+		ticket.ScreenNumber = show.ScreenNumber
+		errTkt := putEntity(stub, ticket, keyTicket, ticket.TicketId)
+		if errTkt != nil {
+			return shim.Error("Failed to reschedule show : " + errTkt.Error())
+		}
+		change.Tickets = append(change.Tickets, TicketHolder{TicketId: ticket.TicketId, Owner: ticket.Owner})
+	}
+
+	change.ShowStatus = show.ShowStatus
+	change.NewShowStart = show.ShowStart
+	change.NewScreenNumber = show.ScreenNumber
+	changeAsBytes, _ := json.Marshal(change)
+	err = stub.SetEvent(EventShowRescheduled, changeAsBytes)
+	if err != nil {
+		return shim.Error("Failed to reschedule show : " + err.Error())
+	}
+
+	fmt.Println("- end reschedule_show")
+	return shim.Success(changeAsBytes)
+}
+
+// ============================================================================================================================
+// cancel_show() - cancel a show of the caller's theatre and start the job refunding every ticket in full
+//
+// No more tickets can be booked for the show. The tickets are refunded by calling process_refund_batch
+// with the returned refundJobId. Ticket holders are told through the ShowCancelled event.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"showId":"value1","reason":"value2"}
+// ============================================================================================================================
+func cancel_show(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting cancel_show")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	showId, _ := jsonValue["showId"].(string)
+	reason, _ := jsonValue["reason"].(string)
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to cancel show : " + err.Error())
+	}
+	show, err := getChangeableShow(stub, showId, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	var change ShowChange
+	change.ShowId = show.ShowId
+	change.TheatreRegNo = show.TheatreRegNo
+	change.OldShowStart = show.ShowStart
+	change.OldScreenNumber = show.ScreenNumber
+	change.Reason = reason
+
+	// tickets are refunded in batches by process_refund_batch
+	job, err := newShowRefundJob(stub, show, reason, now)
+	if err != nil {
+		return shim.Error("Failed to cancel show : " + err.Error())
+	}
+	change.RefundJobId = job.JobId
+
+	// seats of the tickets stay on the seat map as sold, the show is off
+	show.ShowStatus = ShowCancelled
+	errShw := putShow(stub, show)
+	if errShw != nil {
+		return shim.Error("Failed to cancel show : " + errShw.Error())
+	}
+
+	change.ShowStatus = show.ShowStatus
+	changeAsBytes, _ := json.Marshal(change)
+	err = stub.SetEvent(EventShowCancelled, changeAsBytes)
+	if err != nil {
+		return shim.Error("Failed to cancel show : " + err.Error())
+	}
+
+	fmt.Println("- end cancel_show")
+	return shim.Success(changeAsBytes)
+}
+
+// ============================================================================================================================
+// get_show() - read a show with the seats booked and left counted from its seats, and its live status
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"showId":"value1"}
+// ============================================================================================================================
+func get_show(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_show")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	showId, _ := jsonValue["showId"].(string)
+
+	shAsBytes, err := getEntity(stub, keyShow, showId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if shAsBytes == nil {
+		return shim.Error("This show does not exists - " + showId)
+	}
+	show := Shows{}
+	json.Unmarshal(shAsBytes, &show)
+
+	cfg, err := getConfig(stub, show.TheatreRegNo)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = countSeats(stub, &show)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	show.ShowStatus = showStatus(show, now, cfg)
+	showAsBytes, _ := json.Marshal(show)
+
+	fmt.Println("- end get_show")
+	return shim.Success(showAsBytes)
+}
@@ -0,0 +1,83 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// A show is moved with its tickets, or cancelled with a refund job, and ticket holders are told by event
+func TestRescheduleAndCancelShow(t *testing.T) {
+	l := newTestLedger(t, 2, 2)
+	l.ok(l.theatreAdmin, "add_screen", `{"screenNumber":2,"rows":1,"columns":2}`)
+	var ticket, other Tickets
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1"]}`), &ticket)
+	json.Unmarshal(l.ok(l.bob, "book_tickets", `{"showId":"S1","seats":["B1"]}`), &other)
+
+	// screen 2 has no seat B1
+	l.fail(l.theatreAdmin, "reschedule_show", `{"showId":"S1","screenNumber":2,"reason":"AC fault"}`)
+	l.ok(l.bob, "cancel_ticket", `{"ticketId":"`+other.TicketId+`"}`)
+	var change ShowChange
+	json.Unmarshal(l.ok(l.theatreAdmin, "reschedule_show", `{"showId":"S1","screenNumber":2,"showTiming":"2019-12-30 06:00pm","reason":"AC fault"}`), &change)
+	if change.NewScreenNumber != 2 || change.NewShowStart != "2019-12-30T18:00:00Z" || len(change.Tickets) != 1 {
+		t.Fatalf("%+v", change)
+	}
+	if event := l.events[len(l.events)-1]; event.EventName != EventShowRescheduled {
+		t.Fatal(event.EventName)
+	}
+	var shows []Shows
+	json.Unmarshal(l.ok(l.alice, "get_theatre_shows", `{"theatreRegNo":"TH1","showDate":"2019-12-29"}`), &shows)
+	if len(shows) != 0 {
+		t.Fatalf("%+v", shows)
+	}
+	json.Unmarshal(l.ok(l.alice, "read", "entity", "Tickets", ticket.TicketId), &ticket)
+	if ticket.ShowStart != "2019-12-30T18:00:00Z" || ticket.ScreenNumber != 2 {
+		t.Fatalf("%+v", ticket)
+	}
+
+	l.ok(l.bob, "book_tickets", `{"showId":"S1","seats":["A2"]}`)
+	json.Unmarshal(l.ok(l.alice, "get_theatre_shows", `{"theatreRegNo":"TH1","showDate":"2019-12-30"}`), &shows)
+	if len(shows) != 1 || shows[0].ShowStatus != ShowHouseFull {
+		t.Fatalf("%+v", shows)
+	}
+
+	json.Unmarshal(l.ok(l.theatreAdmin, "cancel_show", `{"showId":"S1","reason":"Flood"}`), &change)
+	if change.ShowStatus != ShowCancelled || change.RefundJobId != showRefundJobId("S1") {
+		t.Fatalf("%+v", change)
+	}
+	if event := l.events[len(l.events)-1]; event.EventName != EventShowCancelled {
+		t.Fatal(event.EventName)
+	}
+	l.fail(l.theatreAdmin, "cancel_show", `{"showId":"S1"}`)
+	l.fail(l.alice, "cancel_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+	l.fail(l.alice, "book_tickets", `{"showId":"S1","seats":["B1"]}`)
+}
+
+// Shows are Completed once they are over
+func TestShowCompleted(t *testing.T) {
+	l := newTestLedger(t, 1, 1)
+	l.now = time.Date(2019, 12, 29, 14, 0, 0, 0, time.UTC)
+	var shows []Shows
+	json.Unmarshal(l.ok(l.alice, "get_theatre_shows", `{"theatreRegNo":"TH1","showDate":"2019-12-29"}`), &shows)
+	if len(shows) != 1 || shows[0].ShowStatus != ShowCompleted {
+		t.Fatalf("%+v", shows)
+	}
+	l.fail(l.theatreAdmin, "reschedule_show", `{"showId":"S1","showTiming":"2019-12-30 06:00pm"}`)
+}
@@ -0,0 +1,165 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+// Package ticketcode checks ticket verification codes at the gate of a theatre without a
+// connection to the network.
+//
+// Before the day starts the gate reads the snapshot of the valid codes of its theatre with the
+// get_gate_snapshot query of the MTA chaincode and loads it into a Validator. Codes presented
+// at the gate are checked against the snapshot, each one is admitted once, and the admissions
+// are sent to check_in_ticket when the gate is back online.
+package ticketcode
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors given by Check
+var (
+	ErrUnknownCode      = errors.New("ticketcode: code is not valid for this snapshot")
+	ErrAlreadyAdmitted  = errors.New("ticketcode: ticket already admitted")
+	ErrCheckInNotOpen   = errors.New("ticketcode: check-in is not open yet for this show")
+	ErrCheckInClosed    = errors.New("ticketcode: check-in is closed for this show")
+	ErrSnapshotMismatch = errors.New("ticketcode: snapshot is for another theatre")
+)
+
+// Snapshot - valid codes of a theatre for a day, as given by get_gate_snapshot
+type Snapshot struct {
+	TheatreRegNo string   `json:"theatreRegNo"`
+	ShowDate     string   `json:"showDate"`
+	GeneratedAt  string   `json:"generatedAt"`
+	Tickets      []Ticket `json:"tickets"`
+}
+
+// Ticket - a ticket which can be admitted, with the check-in window of its show
+type Ticket struct {
+	Code          string   `json:"verificationCode"`
+	TicketId      string   `json:"ticketId"`
+	ShowId        string   `json:"showId"`
+	ShowStart     string   `json:"showStart"`
+	ScreenNumber  int      `json:"screenNumber"`
+	Seats         []string `json:"seats"`
+	CheckInOpens  string   `json:"checkInOpens"`
+	CheckInCloses string   `json:"checkInCloses"`
+}
+
+// Admission - a ticket admitted offline, the arguments of check_in_ticket once back online
+type Admission struct {
+	TicketId   string `json:"ticketId"`
+	Code       string `json:"code"`
+	AdmittedAt string `json:"admittedAt"`
+}
+
+// Validator checks codes against a snapshot and keeps the admissions made. It is safe to use
+// from several scanners at once.
+type Validator struct {
+	mu         sync.Mutex
+	snapshot   Snapshot
+	tickets    map[string]Ticket
+	admitted   map[string]bool
+	admissions []Admission
+}
+
+// Digits misread for the letters of a code, codes only have the digits 2 to 7
+var lookalikes = strings.NewReplacer("0", "O", "1", "I", "8", "B")
+
+// Normalize gives a code as the chaincode issues it - upper case, in groups of four split by dashes.
+// Codes typed in lower case, without the dashes or with 0, 1 or 8 for O, I or B are accepted.
+func Normalize(code string) string {
+	var compact strings.Builder
+	for _, r := range strings.ToUpper(lookalikes.Replace(code)) {
+		if r != '-' && r != ' ' {
+			compact.WriteRune(r)
+		}
+	}
+	plain := compact.String()
+	var grouped strings.Builder
+	for i, r := range plain {
+		if i > 0 && i%4 == 0 {
+			grouped.WriteByte('-')
+		}
+		grouped.WriteRune(r)
+	}
+	return grouped.String()
+}
+
+// ParseSnapshot reads a snapshot from the JSON given by get_gate_snapshot
+func ParseSnapshot(data []byte) (Snapshot, error) {
+	var snapshot Snapshot
+	err := json.Unmarshal(data, &snapshot)
+	return snapshot, err
+}
+
+// NewValidator loads a snapshot
+func NewValidator(snapshot Snapshot) *Validator {
+	v := &Validator{snapshot: snapshot, tickets: make(map[string]Ticket), admitted: make(map[string]bool)}
+	for _, ticket := range snapshot.Tickets {
+		v.tickets[Normalize(ticket.Code)] = ticket
+	}
+	return v
+}
+
+// Merge adds the tickets of a newer snapshot of the same theatre, keeping the admissions made.
+// Tickets missing from the newer snapshot (cancelled or passed on since) are no longer valid.
+func (v *Validator) Merge(snapshot Snapshot) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if snapshot.TheatreRegNo != v.snapshot.TheatreRegNo {
+		return ErrSnapshotMismatch
+	}
+	v.snapshot = snapshot
+	v.tickets = make(map[string]Ticket)
+	for _, ticket := range snapshot.Tickets {
+		v.tickets[Normalize(ticket.Code)] = ticket
+	}
+	return nil
+}
+
+// Check admits the ticket of a code at the time given. A code is admitted only once.
+func (v *Validator) Check(code string, now time.Time) (Ticket, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	ticket, ok := v.tickets[Normalize(code)]
+	if !ok {
+		return Ticket{}, ErrUnknownCode
+	}
+	if v.admitted[ticket.TicketId] {
+		return ticket, ErrAlreadyAdmitted
+	}
+	if opens, err := time.Parse(time.RFC3339, ticket.CheckInOpens); err == nil && now.Before(opens) {
+		return ticket, ErrCheckInNotOpen
+	}
+	if closes, err := time.Parse(time.RFC3339, ticket.CheckInCloses); err == nil && !now.Before(closes) {
+		return ticket, ErrCheckInClosed
+	}
+	v.admitted[ticket.TicketId] = true
+	v.admissions = append(v.admissions, Admission{TicketId: ticket.TicketId, Code: ticket.Code, AdmittedAt: now.UTC().Format(time.RFC3339)})
+	return ticket, nil
+}
+
+// Admissions gives the tickets admitted so far, in the order they were admitted
+func (v *Validator) Admissions() []Admission {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	admissions := make([]Admission, len(v.admissions))
+	copy(admissions, v.admissions)
+	return admissions
+}
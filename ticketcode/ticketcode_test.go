@@ -0,0 +1,149 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package ticketcode
+
+import (
+	"testing"
+	"time"
+)
+
+var show = time.Date(2019, 12, 29, 10, 0, 0, 0, time.UTC)
+
+func testSnapshot(tickets ...Ticket) Snapshot {
+	return Snapshot{TheatreRegNo: "TH1", ShowDate: "2019-12-29", Tickets: tickets}
+}
+
+func testTicket(ticketId string, code string) Ticket {
+	return Ticket{
+		Code:          code,
+		TicketId:      ticketId,
+		ShowId:        "S1",
+		Seats:         []string{"A1"},
+		CheckInOpens:  show.Add(-time.Hour).Format(time.RFC3339),
+		CheckInCloses: show.Add(30 * time.Minute).Format(time.RFC3339),
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for typed, code := range map[string]string{
+		"ABCD-EFGH-IJKL-MNOP": "ABCD-EFGH-IJKL-MNOP",
+		"abcd-efgh-ijkl-mnop": "ABCD-EFGH-IJKL-MNOP",
+		"abcdefghijklmnop":    "ABCD-EFGH-IJKL-MNOP",
+		"AbCd EfGh-IJKL mnop": "ABCD-EFGH-IJKL-MNOP",
+		"A-B-C-D-E-F-G-H":     "ABCD-EFGH",
+		"0123-4567-8ABC-D2E3": "OI23-4567-BABC-D2E3",
+		"":                    "",
+	} {
+		if got := Normalize(typed); got != code {
+			t.Errorf("Normalize(%q) = %q, want %q", typed, got, code)
+		}
+	}
+}
+
+func TestCheckAdmitsOnce(t *testing.T) {
+	v := NewValidator(testSnapshot(testTicket("T1", "ABCD-EFGH-IJKL-MNOP")))
+	now := show.Add(-10 * time.Minute)
+
+	ticket, err := v.Check("abcd efgh ijkl mnop", now)
+	if err != nil || ticket.TicketId != "T1" {
+		t.Fatalf("Check = %+v, %v", ticket, err)
+	}
+	if _, err := v.Check("ABCDEFGHIJKLMNOP", now.Add(time.Minute)); err != ErrAlreadyAdmitted {
+		t.Fatalf("second Check = %v, want %v", err, ErrAlreadyAdmitted)
+	}
+
+	admissions := v.Admissions()
+	if len(admissions) != 1 || admissions[0].TicketId != "T1" || admissions[0].Code != "ABCD-EFGH-IJKL-MNOP" ||
+		admissions[0].AdmittedAt != now.Format(time.RFC3339) {
+		t.Fatalf("Admissions = %+v", admissions)
+	}
+}
+
+func TestCheckWindow(t *testing.T) {
+	v := NewValidator(testSnapshot(testTicket("T1", "ABCD-EFGH-IJKL-MNOP")))
+	if _, err := v.Check("ABCD-EFGH-IJKL-MNOP", show.Add(-2*time.Hour)); err != ErrCheckInNotOpen {
+		t.Fatalf("Check before the window = %v, want %v", err, ErrCheckInNotOpen)
+	}
+	if _, err := v.Check("ABCD-EFGH-IJKL-MNOP", show.Add(30*time.Minute)); err != ErrCheckInClosed {
+		t.Fatalf("Check after the window = %v, want %v", err, ErrCheckInClosed)
+	}
+	if len(v.Admissions()) != 0 {
+		t.Fatalf("Admissions = %+v", v.Admissions())
+	}
+}
+
+func TestCheckCodeOfAnotherTicket(t *testing.T) {
+	v := NewValidator(testSnapshot(testTicket("T1", "ABCD-EFGH-IJKL-MNOP"), testTicket("T2", "QRST-UVWX-YZ23-4567")))
+	now := show.Add(-10 * time.Minute)
+	for _, code := range []string{"ABCD-EFGH-IJKL-MNOQ", "ABCD-EFGH-IJKL", "QRST-UVWX-YZ23-4567-ABCD"} {
+		if _, err := v.Check(code, now); err != ErrUnknownCode {
+			t.Errorf("Check(%q) = %v, want %v", code, err, ErrUnknownCode)
+		}
+	}
+	ticket, err := v.Check("QRST-UVWX-YZ23-4567", now)
+	if err != nil || ticket.TicketId != "T2" {
+		t.Fatalf("Check = %+v, %v", ticket, err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	v := NewValidator(testSnapshot(testTicket("T1", "ABCD-EFGH-IJKL-MNOP"), testTicket("T2", "QRST-UVWX-YZ23-4567")))
+	now := show.Add(-10 * time.Minute)
+	if _, err := v.Check("ABCD-EFGH-IJKL-MNOP", now); err != nil {
+		t.Fatal(err)
+	}
+
+	// T2 was passed on, its code changed with the owner, and T3 was booked since
+	err := v.Merge(testSnapshot(testTicket("T1", "ABCD-EFGH-IJKL-MNOP"), testTicket("T2", "ZZZZ-YYYY-XXXX-WWWW"), testTicket("T3", "2222-3333-4444-5555")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Check("ABCD-EFGH-IJKL-MNOP", now); err != ErrAlreadyAdmitted {
+		t.Fatalf("Check of a ticket admitted before the merge = %v, want %v", err, ErrAlreadyAdmitted)
+	}
+	if _, err := v.Check("QRST-UVWX-YZ23-4567", now); err != ErrUnknownCode {
+		t.Fatalf("Check of the code of the previous owner = %v, want %v", err, ErrUnknownCode)
+	}
+	for _, code := range []string{"ZZZZ-YYYY-XXXX-WWWW", "2222-3333-4444-5555"} {
+		if _, err := v.Check(code, now); err != nil {
+			t.Fatalf("Check(%q) = %v", code, err)
+		}
+	}
+	if len(v.Admissions()) != 3 {
+		t.Fatalf("Admissions = %+v", v.Admissions())
+	}
+
+	other := testSnapshot()
+	other.TheatreRegNo = "TH2"
+	if err := v.Merge(other); err != ErrSnapshotMismatch {
+		t.Fatalf("Merge of another theatre = %v, want %v", err, ErrSnapshotMismatch)
+	}
+	if _, err := v.Check("2222-3333-4444-5555", now); err != ErrAlreadyAdmitted {
+		t.Fatalf("Check after a rejected merge = %v, want %v", err, ErrAlreadyAdmitted)
+	}
+}
+
+func TestParseSnapshot(t *testing.T) {
+	snapshot, err := ParseSnapshot([]byte(`{"theatreRegNo":"TH1","showDate":"2019-12-29","tickets":[{"verificationCode":"ABCD-EFGH-IJKL-MNOP","ticketId":"T1","seats":["A1"]}]}`))
+	if err != nil || snapshot.TheatreRegNo != "TH1" || len(snapshot.Tickets) != 1 || snapshot.Tickets[0].Code != "ABCD-EFGH-IJKL-MNOP" {
+		t.Fatalf("ParseSnapshot = %+v, %v", snapshot, err)
+	}
+	if _, err := ParseSnapshot([]byte(`{"tickets":`)); err == nil {
+		t.Fatal("ParseSnapshot of a truncated snapshot gave no error")
+	}
+}
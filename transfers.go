@@ -0,0 +1,407 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Reads a ticket from the ledger
+func getTicket(stub shim.ChaincodeStubInterface, ticketId string) (Tickets, error) {
+	var ticket Tickets
+	tktAsBytes, err := getEntity(stub, keyTicket, ticketId)
+	if err != nil {
+		return ticket, err
+	}
+	if tktAsBytes == nil {
+		return ticket, errors.New("This ticket does not exists - " + ticketId)
+	}
+	err = json.Unmarshal(tktAsBytes, &ticket)
+	return ticket, err
+}
+
+// Checks a ticket can change hands - booked, and its show neither cancelled nor started
+func checkTransferable(stub shim.ChaincodeStubInterface, ticket Tickets, now time.Time) error {
+	if ticket.Status != TicketBooked {
+		return errors.New("This ticket is " + ticket.Status + " - " + ticket.TicketId)
+	}
+	start, err := showStartTime(ticket.ShowStart, ticket.ShowTiming)
+	if err != nil {
+		return err
+	}
+	if !now.Before(start) {
+		return errors.New("Tickets cannot change hands once the show has started - " + ticket.TicketId)
+	}
+	shAsBytes, _ := getEntity(stub, keyShow, ticket.ShowId)
+	show := Shows{}
+	json.Unmarshal(shAsBytes, &show)
+	if show.ShowStatus == ShowCancelled {
+		return errors.New("The show of this ticket is cancelled - " + ticket.TicketId)
+	}
+	return nil
+}
+
+// Gives a ticket to a new owner and moves it in the owner~ticket index. Every owner stays on
+// the history of the ticket key, which getHistory reads as the chain of custody. The tickets
+// count for the purchase limits of the new owner.
+func changeTicketOwner(stub shim.ChaincodeStubInterface, ticket Tickets, owner string, price int, now time.Time) (Tickets, error) {
+	start, err := showStartTime(ticket.ShowStart, ticket.ShowTiming)
+	if err != nil {
+		return ticket, err
+	}
+	show, err := getShow(stub, ticket.ShowId)
+	if err != nil {
+		return ticket, err
+	}
+	cfg, err := getConfig(stub, show.TheatreRegNo)
+	if err != nil {
+		return ticket, err
+	}
+	err = addPurchase(stub, cfg, owner, show, ticket.NumberOfTickets)
+	if err != nil {
+		return ticket, err
+	}
+	showStart := start.UTC().Format(time.RFC3339)
+	err = delIndex(stub, indexOwnerTicket, ticket.Owner, showStart, ticket.TicketId)
+	if err != nil {
+		return ticket, err
+	}
+	err = putIndex(stub, indexOwnerTicket, owner, showStart, ticket.TicketId)
+	if err != nil {
+		return ticket, err
+	}
+	if ticket.ResalePrice > 0 {
+		err = delIndex(stub, indexResale, ticket.ShowId, ticket.TicketId)
+		if err != nil {
+			return ticket, err
+		}
+	}
+
+	ticket.PreviousOwner = ticket.Owner
+	ticket.Owner = owner
+	ticket.TransferredAt = now.Format(time.RFC3339)
+	ticket.TransferPrice = price
+	ticket.ResalePrice = 0
+	ticket.CountedFor = owner
+	ticket.CountedDate = show.ShowDate
+	ticket.VerifyCode = verificationCode(ticket)
+	err = putEntity(stub, ticket, keyTicket, ticket.TicketId)
+	return ticket, err
+}
+
+// Checks an owner id - the hex sha256 given by get_my_tickets
+func validOwnerId(ownerId string) bool {
+	digest, err := hex.DecodeString(ownerId)
+	return err == nil && len(digest) == 32
+}
+
+// ============================================================================================================================
+// transfer_ticket() - give a ticket to another buyer, named by the ownerId that get_my_tickets gives them
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"ticketId":"value1","to":"ownerId of the recipient"}
+// ============================================================================================================================
+func transfer_ticket(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting transfer_ticket")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	var request struct {
+		TicketId string `json:"ticketId"`
+		To       string `json:"to"`
+	}
+	json.Unmarshal([]byte(args[0]), &request)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	ticket, err := getTicket(stub, request.TicketId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if ticket.Owner != caller.OwnerId() {
+		return codedError(ErrAccessDenied, "Only the owner of the ticket can transfer it - "+request.TicketId)
+	}
+	if !validOwnerId(request.To) {
+		return shim.Error("Expecting the ownerId of the recipient under \"to\"")
+	}
+	if request.To == ticket.Owner {
+		return shim.Error("This ticket is already owned by " + request.To)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to transfer ticket : " + err.Error())
+	}
+	err = checkTransferable(stub, ticket, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ticket, err = changeTicketOwner(stub, ticket, request.To, 0, now)
+	if err != nil {
+		return purchaseError("Failed to transfer ticket : ", err)
+	}
+
+	ticketAsBytes, _ := json.Marshal(ticket)
+	fmt.Println("- end transfer_ticket")
+	return shim.Success(ticketAsBytes)
+}
+
+// ============================================================================================================================
+// list_ticket_for_resale() - offer a ticket for resale, or change its asking price
+//
+// The price cannot be higher than resalePriceCapPercent of the config, as a percent of the price paid for the ticket.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"ticketId":"value1","price":200}
+// ============================================================================================================================
+func list_ticket_for_resale(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting list_ticket_for_resale")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	var request struct {
+		TicketId string `json:"ticketId"`
+		Price    int    `json:"price"`
+	}
+	json.Unmarshal([]byte(args[0]), &request)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	ticket, err := getTicket(stub, request.TicketId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if ticket.Owner != caller.OwnerId() {
+		return codedError(ErrAccessDenied, "Only the owner of the ticket can resell it - "+request.TicketId)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to list ticket : " + err.Error())
+	}
+	err = checkTransferable(stub, ticket, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	cfg, err := getConfig(stub, ticket.TheatreRegNo)
+	if err != nil {
+		return shim.Error("Failed to list ticket : " + err.Error())
+	}
+	priceCap := ticket.TotalPrice * cfg.ResalePriceCapPercent / 100
+	if request.Price < 1 || request.Price > priceCap {
+		return shim.Error("The resale price must be between 1 and " + strconv.Itoa(priceCap) + " for ticket " + ticket.TicketId)
+	}
+
+	if ticket.ResalePrice == 0 {
+		errIdx := putIndex(stub, indexResale, ticket.ShowId, ticket.TicketId)
+		if errIdx != nil {
+			return shim.Error("Failed to list ticket : " + errIdx.Error())
+		}
+	}
+	ticket.ResalePrice = request.Price
+	errTkt := putEntity(stub, ticket, keyTicket, ticket.TicketId)
+	if errTkt != nil {
+		return shim.Error("Failed to list ticket : " + errTkt.Error())
+	}
+
+	ticketAsBytes, _ := json.Marshal(ticket)
+	fmt.Println("- end list_ticket_for_resale")
+	return shim.Success(ticketAsBytes)
+}
+
+// ============================================================================================================================
+// cancel_resale() - take a ticket off resale
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"ticketId":"value1"}
+// ============================================================================================================================
+func cancel_resale(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting cancel_resale")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	ticketId, _ := jsonValue["ticketId"].(string)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	ticket, err := getTicket(stub, ticketId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if ticket.Owner != caller.OwnerId() {
+		return codedError(ErrAccessDenied, "Only the owner of the ticket can take it off resale - "+ticketId)
+	}
+	if ticket.ResalePrice == 0 {
+		return shim.Error("This ticket is not listed for resale - " + ticketId)
+	}
+
+	errIdx := delIndex(stub, indexResale, ticket.ShowId, ticket.TicketId)
+	if errIdx != nil {
+		return shim.Error("Failed to cancel resale : " + errIdx.Error())
+	}
+	ticket.ResalePrice = 0
+	errTkt := putEntity(stub, ticket, keyTicket, ticket.TicketId)
+	if errTkt != nil {
+		return shim.Error("Failed to cancel resale : " + errTkt.Error())
+	}
+
+	ticketAsBytes, _ := json.Marshal(ticket)
+	fmt.Println("- end cancel_resale")
+	return shim.Success(ticketAsBytes)
+}
+
+// ============================================================================================================================
+// buy_resale_ticket() - buy a ticket listed for resale, the ticket passes to the caller in the same transaction
+//
+// The price sent must be the asking price, so a seller raising it in between does not charge the buyer more.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"ticketId":"value1","price":200}
+// ============================================================================================================================
+func buy_resale_ticket(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting buy_resale_ticket")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	var request struct {
+		TicketId string `json:"ticketId"`
+		Price    int    `json:"price"`
+	}
+	json.Unmarshal([]byte(args[0]), &request)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	ticket, err := getTicket(stub, request.TicketId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if ticket.ResalePrice == 0 {
+		return shim.Error("This ticket is not listed for resale - " + request.TicketId)
+	}
+	if ticket.Owner == caller.OwnerId() {
+		return shim.Error("This ticket is already owned by the caller - " + request.TicketId)
+	}
+	if request.Price != ticket.ResalePrice {
+		return shim.Error("The asking price of ticket " + ticket.TicketId + " is " + strconv.Itoa(ticket.ResalePrice))
+	}
+
+	// only tickets listed through list_ticket_for_resale, at a price within the cap, are sold
+	entries, err := getIndex(stub, indexResale, ticket.ShowId, ticket.TicketId)
+	if err != nil {
+		return shim.Error("Failed to buy ticket : " + err.Error())
+	}
+	if len(entries) == 0 {
+		return shim.Error("This ticket is not listed for resale - " + request.TicketId)
+	}
+	cfg, err := getConfig(stub, ticket.TheatreRegNo)
+	if err != nil {
+		return shim.Error("Failed to buy ticket : " + err.Error())
+	}
+	if ticket.ResalePrice > ticket.TotalPrice*cfg.ResalePriceCapPercent/100 {
+		return shim.Error("The asking price of ticket " + ticket.TicketId + " is above the resale price cap")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to buy ticket : " + err.Error())
+	}
+	err = checkTransferable(stub, ticket, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	ticket, err = changeTicketOwner(stub, ticket, caller.OwnerId(), request.Price, now)
+	if err != nil {
+		return purchaseError("Failed to buy ticket : ", err)
+	}
+
+	ticketAsBytes, _ := json.Marshal(ticket)
+	fmt.Println("- end buy_resale_ticket")
+	return shim.Success(ticketAsBytes)
+}
+
+// ============================================================================================================================
+// get_resale_tickets() - read the tickets of a show listed for resale
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"showId":"value1"}
+// ============================================================================================================================
+func get_resale_tickets(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_resale_tickets")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	showId, _ := jsonValue["showId"].(string)
+
+	entries, err := getIndex(stub, indexResale, showId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	tickets := []Tickets{}
+	for _, entry := range entries {
+		ticket, err := getTicket(stub, entry[1])
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		tickets = append(tickets, ticket)
+	}
+	ticketsAsBytes, _ := json.Marshal(tickets)
+
+	fmt.Println("- end get_resale_tickets")
+	return shim.Success(ticketsAsBytes)
+}
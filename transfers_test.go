@@ -0,0 +1,46 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+)
+
+// Only tickets listed by their owner, at a price within the cap, can be bought on resale
+func TestResaleOnlyOfListedTickets(t *testing.T) {
+	l := newTestLedger(t, 10, 10)
+
+	// a resale price sent with the booking is not kept on the ticket
+	var ticket Tickets
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1"],"resalePrice":1}`), &ticket)
+	if ticket.ResalePrice != 0 || ticket.TotalPrice < 2 {
+		t.Fatalf("%+v", ticket)
+	}
+	l.fail(l.bob, "buy_resale_ticket", `{"ticketId":"`+ticket.TicketId+`","price":1}`)
+
+	// listed at the cap, the cap lowered before it is bought
+	price := ticket.TotalPrice
+	l.ok(l.alice, "list_ticket_for_resale", `{"ticketId":"`+ticket.TicketId+`","price":`+strconv.Itoa(price)+`}`)
+	l.ok(l.admin, "set_config", `{"config":{"resalePriceCapPercent":50}}`)
+	l.fail(l.bob, "buy_resale_ticket", `{"ticketId":"`+ticket.TicketId+`","price":`+strconv.Itoa(price)+`}`)
+
+	l.ok(l.alice, "list_ticket_for_resale", `{"ticketId":"`+ticket.TicketId+`","price":1}`)
+	l.ok(l.bob, "buy_resale_ticket", `{"ticketId":"`+ticket.TicketId+`","price":1}`)
+}
@@ -0,0 +1,578 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	pb "github.com/hyperledger/fabric/protos/peer"
+)
+
+// Waitlist states of a buyer. An Offered buyer has a hold on the seats until the offer expires,
+// expire_offers then marks the entry Expired and passes the seats on.
+const (
+	WaitlistWaiting = "Waiting"
+	WaitlistOffered = "Offered"
+	WaitlistLeft    = "Left"
+	WaitlistExpired = "Expired"
+)
+
+// Event sent when seats given back are offered to buyers of the waitlist
+const EventWaitlistOffer = "WaitlistOffer"
+
+// Layout of the join time in the waitlist~show index, fixed width so the keys sort by time
+const waitlistTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// WaitlistEntry Struct - a buyer waiting for seats of a sold out show
+type WaitlistEntry struct {
+	ObjectType   string `json:"docType"` // field defined for couchdb
+	ShowId       string `json:"showId"`
+	TheatreRegNo string `json:"theatreRegNo"`
+	Owner        string `json:"owner"`
+	Seats        int    `json:"seats"` // number of seats wanted
+	Status       string `json:"status"`
+	JoinedAt     string `json:"joinedAt"` // time of the transaction, the place in the line
+	HoldId       string `json:"holdId"`   // hold offered to the buyer
+	OfferedAt    string `json:"offeredAt"`
+	Position     int    `json:"position,omitempty"` // place in the line, only given by get_waitlist
+}
+
+// WaitlistOffer Struct - seats held for a buyer of the waitlist, confirmed with confirm_hold
+type WaitlistOffer struct {
+	Owner      string   `json:"owner"`
+	HoldId     string   `json:"holdId"`
+	Seats      []string `json:"seats"`
+	TotalPrice int      `json:"totalPrice"`
+	ExpiresAt  string   `json:"expiresAt"`
+}
+
+// WaitlistOffers Struct - payload of the WaitlistOffer event
+type WaitlistOffers struct {
+	ShowId       string          `json:"showId"`
+	TheatreRegNo string          `json:"theatreRegNo"`
+	Offers       []WaitlistOffer `json:"offers"`
+}
+
+// Reads the waitlist entry of a buyer for a show, nil when the buyer never joined
+func getWaitlistEntry(stub shim.ChaincodeStubInterface, showId string, owner string) (*WaitlistEntry, error) {
+	entryAsBytes, err := getEntity(stub, keyWaitlist, showId, owner)
+	if err != nil || entryAsBytes == nil {
+		return nil, err
+	}
+	var entry WaitlistEntry
+	err = json.Unmarshal(entryAsBytes, &entry)
+	return &entry, err
+}
+
+// Reads the buyers waiting for a show, in the order they joined
+func getWaitingEntries(stub shim.ChaincodeStubInterface, showId string) ([]WaitlistEntry, error) {
+	indexEntries, err := getIndex(stub, indexWaitlist, showId)
+	if err != nil {
+		return nil, err
+	}
+	var entries []WaitlistEntry
+	for _, indexEntry := range indexEntries {
+		entry, err := getWaitlistEntry(stub, showId, indexEntry[2])
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil && entry.Status == WaitlistWaiting {
+			entries = append(entries, *entry)
+		}
+	}
+	return entries, nil
+}
+
+// Puts seats given back on hold for the buyers of the waitlist, in the order they joined, and sends
+// the WaitlistOffer event. A buyer is offered seats only when enough are given back for all the seats
+// wanted, else the buyer keeps the place and the next buyer is tried. sm holds the seats given back.
+func offerToWaitlist(stub shim.ChaincodeStubInterface, show Shows, sm SeatMap, now time.Time) error {
+	var free []string
+	for _, seat := range sm.Seats {
+		if seat.Status == SeatFree {
+			free = append(free, seat.SeatId)
+		}
+	}
+	if len(free) == 0 || show.ShowStatus == ShowCancelled {
+		return nil
+	}
+	entries, err := getWaitingEntries(stub, show.ShowId)
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	mov, start, err := checkBookable(stub, show, now)
+	if err != nil {
+		return nil // seats can no longer be booked, nothing to offer
+	}
+	cfg, err := getConfig(stub, show.TheatreRegNo)
+	if err != nil {
+		return err
+	}
+
+	offers := WaitlistOffers{ShowId: show.ShowId, TheatreRegNo: show.TheatreRegNo}
+	expiresAt := now.Add(time.Duration(cfg.WaitlistOfferMinutes) * time.Minute)
+	for _, entry := range entries {
+		if entry.Seats > len(free) {
+			continue
+		}
+		var hold SeatHold
+		hold.ObjectType = "SeatHold"
+		hold.HoldId = "H" + txScopedId(stub) + "W" + strconv.Itoa(len(offers.Offers)+1)
+		hold.ShowId = show.ShowId
+		hold.TheatreRegNo = show.TheatreRegNo
+		hold.Seats = free[:entry.Seats]
+		hold.Owner = entry.Owner
+		hold.Status = HoldActive
+		hold.HeldAt = now.Format(time.RFC3339)
+		hold.ExpiresAt = expiresAt.Format(time.RFC3339)
+		hold.Waitlist = true
+		err = holdSeats(&sm, hold.Seats, hold.HoldId, expiresAt, now)
+		if err == nil {
+			err = quoteHold(stub, show, mov, start, &hold, sm)
+		}
+		if err == nil {
+			err = putEntity(stub, hold, keyHold, hold.HoldId)
+		}
+		if err != nil {
+			return err
+		}
+		free = free[entry.Seats:]
+
+		err = delIndex(stub, indexWaitlist, show.ShowId, entry.JoinedAt, entry.Owner)
+		if err != nil {
+			return err
+		}
+		entry.Status = WaitlistOffered
+		entry.HoldId = hold.HoldId
+		entry.OfferedAt = now.Format(time.RFC3339)
+		err = putEntity(stub, entry, keyWaitlist, show.ShowId, entry.Owner)
+		if err != nil {
+			return err
+		}
+		offers.Offers = append(offers.Offers, WaitlistOffer{Owner: hold.Owner, HoldId: hold.HoldId, Seats: hold.Seats, TotalPrice: hold.TotalPrice, ExpiresAt: hold.ExpiresAt})
+		if len(free) == 0 {
+			break
+		}
+	}
+	if len(offers.Offers) == 0 {
+		return nil
+	}
+
+	err = putSeats(stub, sm)
+	if err != nil {
+		return err
+	}
+	offersAsBytes, _ := json.Marshal(offers)
+	return stub.SetEvent(EventWaitlistOffer, offersAsBytes)
+}
+
+// Gives the seats of sm held by waitlist offers which expired while buyers are waiting for the show,
+// they go to the buyers in line first and not to the first buyer to book them. The holds and the
+// waitlist are only read for seats of an expired hold.
+func lapsedOfferSeats(stub shim.ChaincodeStubInterface, show Shows, sm SeatMap, now time.Time) (map[string]bool, error) {
+	lapsed := make(map[string]bool)
+	offers := make(map[string]bool) // holdId, true for an offer to a buyer of the waitlist
+	waiting := -1
+	for _, seat := range sm.Seats {
+		if seat.Status != SeatHeld || !holdExpired(seat, now) {
+			continue
+		}
+		offer, read := offers[seat.HoldId]
+		if !read {
+			hold, err := getHold(stub, seat.HoldId)
+			if err != nil {
+				return nil, err
+			}
+			offer = hold.Waitlist
+			offers[seat.HoldId] = offer
+		}
+		if !offer {
+			continue
+		}
+		if waiting < 0 {
+			entries, err := getIndex(stub, indexWaitlist, show.ShowId)
+			if err != nil {
+				return nil, err
+			}
+			waiting = len(entries)
+		}
+		if waiting == 0 {
+			return lapsed, nil
+		}
+		lapsed[seat.SeatId] = true
+	}
+	return lapsed, nil
+}
+
+// Fails when a seat requested is held by a waitlist offer which expired while buyers are waiting for
+// the show. join_waitlist passes such seats on to the buyers in line and adds the caller after them.
+func checkLapsedOffers(stub shim.ChaincodeStubInterface, show Shows, sm SeatMap, now time.Time) error {
+	lapsed, err := lapsedOfferSeats(stub, show, sm, now)
+	if err != nil {
+		return err
+	}
+	for _, seat := range sm.Seats {
+		if lapsed[seat.SeatId] {
+			return errors.New("Seat " + seat.SeatId + " of an expired waitlist offer goes to the buyers waiting for show " + show.ShowId + " first, join the waitlist to be offered seats")
+		}
+	}
+	return nil
+}
+
+// Marks the waitlist offers of the show left to expire, and their buyers, Expired and offers their
+// seats to the next buyers in line. Seats no buyer in line can take are freed for every booking.
+// sm holds every seat of the show. Gives the waitlist entries of the buyers whose offer expired.
+func passLapsedOffers(stub shim.ChaincodeStubInterface, show Shows, sm SeatMap, now time.Time) ([]WaitlistEntry, error) {
+	freed := SeatMap{ObjectType: sm.ObjectType, ShowId: sm.ShowId, Rows: sm.Rows, Columns: sm.Columns}
+	expired := []WaitlistEntry{}
+	read := make(map[string]bool)
+	for _, seat := range sm.Seats {
+		if seat.Status != SeatHeld || !holdExpired(seat, now) || read[seat.HoldId] {
+			continue
+		}
+		read[seat.HoldId] = true
+		hold, err := getHold(stub, seat.HoldId)
+		if err != nil {
+			return nil, err
+		}
+		if !hold.Waitlist {
+			continue
+		}
+		for _, held := range sm.Seats {
+			if held.Status == SeatHeld && held.HoldId == hold.HoldId {
+				freed.Seats = append(freed.Seats, held)
+			}
+		}
+		hold.Status = HoldExpired
+		err = putEntity(stub, hold, keyHold, hold.HoldId)
+		if err != nil {
+			return nil, err
+		}
+
+		entry, err := getWaitlistEntry(stub, show.ShowId, hold.Owner)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil && entry.Status == WaitlistOffered && entry.HoldId == hold.HoldId {
+			entry.Status = WaitlistExpired
+			err = putEntity(stub, entry, keyWaitlist, entry.ShowId, entry.Owner)
+			if err != nil {
+				return nil, err
+			}
+			expired = append(expired, *entry)
+		}
+	}
+
+	if len(freed.Seats) == 0 {
+		return expired, nil
+	}
+	clearExpiredHolds(&freed, now)
+	err := putSeats(stub, freed)
+	if err != nil {
+		return nil, err
+	}
+	return expired, offerToWaitlist(stub, show, freed, now)
+}
+
+// ============================================================================================================================
+// expire_offers() - pass the seats of waitlist offers left to expire to the next buyers in line
+//
+// Buyers who let their offer expire are marked Expired and can join the waitlist again. Seats no
+// buyer in line can take are freed for every booking.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"showId":"value1"}
+// ============================================================================================================================
+func expire_offers(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting expire_offers")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	showId, _ := jsonValue["showId"].(string)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	show, err := getShow(stub, showId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if caller.Role != RolePlatformAdmin && caller.TheatreRegNo != show.TheatreRegNo {
+		return codedError(ErrAccessDenied, "Only the staff of theatre "+show.TheatreRegNo+" can expire its offers")
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to expire offers : " + err.Error())
+	}
+
+	// every seat is read, the sweep conflicts with bookings of the same block
+	sm, err := getSeatMap(stub, show.ShowId)
+	if err != nil {
+		return shim.Error("Failed to expire offers : " + err.Error())
+	}
+	expired, err := passLapsedOffers(stub, show, sm, now)
+	if err != nil {
+		return shim.Error("Failed to expire offers : " + err.Error())
+	}
+
+	expiredAsBytes, _ := json.Marshal(expired)
+	fmt.Println("- end expire_offers")
+	return shim.Success(expiredAsBytes)
+}
+
+// ============================================================================================================================
+// join_waitlist() - wait for seats of a sold out show, seats given back are offered in the order buyers joined
+//
+// The show is sold out when no seat is free or held by a hold which has expired, seats of offers left to
+// expire while buyers wait count as sold. Such offers are passed on to the buyers already in line
+// before the caller joins. A buyer already waiting passes them on the same way and gets the entries
+// of the buyers whose offer expired, as from expire_offers. An offer is a hold of waitlistOfferMinutes
+// of the config, confirmed with confirm_hold like any other hold.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"showId":"value1","seats":2}
+// ============================================================================================================================
+func join_waitlist(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting join_waitlist")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var request struct {
+		ShowId string `json:"showId"`
+		Seats  int    `json:"seats"`
+	}
+	json.Unmarshal([]byte(args[0]), &request)
+	if request.Seats < 1 {
+		return shim.Error("Expecting the number of seats wanted under \"seats\"")
+	}
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	show, err := getShow(stub, request.ShowId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to join waitlist : " + err.Error())
+	}
+	_, _, err = checkBookable(stub, show, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	cfg, err := getConfig(stub, show.TheatreRegNo)
+	if err != nil {
+		return shim.Error("Failed to join waitlist : " + err.Error())
+	}
+	err = checkTransactionLimit(cfg, request.Seats)
+	if err == nil {
+		_, _, err = checkBuyerLimits(stub, cfg, caller.OwnerId(), show, request.Seats)
+	}
+	if err != nil {
+		return purchaseError("Failed to join waitlist : ", err)
+	}
+
+	// every seat is read, joining conflicts with bookings of the same block which is rare once sold out
+	sm, err := getSeatMap(stub, show.ShowId)
+	if err != nil {
+		return shim.Error("Failed to join waitlist : " + err.Error())
+	}
+	lapsed, err := lapsedOfferSeats(stub, show, sm, now)
+	if err != nil {
+		return shim.Error("Failed to join waitlist : " + err.Error())
+	}
+	for _, seat := range sm.Seats {
+		if lapsed[seat.SeatId] {
+			continue
+		}
+		if seat.Status == SeatFree || (seat.Status == SeatHeld && holdExpired(seat, now)) {
+			return shim.Error("Show " + show.ShowId + " still has seats to book, seat " + seat.SeatId + " is free")
+		}
+	}
+
+	entry, err := getWaitlistEntry(stub, show.ShowId, caller.OwnerId())
+	if err != nil {
+		return shim.Error("Failed to join waitlist : " + err.Error())
+	}
+	if entry != nil && entry.Status == WaitlistWaiting && len(lapsed) == 0 {
+		return shim.Error("Already waiting for show " + show.ShowId + " since " + entry.JoinedAt)
+	}
+	if entry != nil && entry.Status == WaitlistOffered {
+		hold, err := getHold(stub, entry.HoldId)
+		if err == nil && holdStatus(hold, now) == HoldActive {
+			return shim.Error("Seats of show " + show.ShowId + " are already offered with hold " + entry.HoldId)
+		}
+	}
+
+	// offers left to expire go to the buyers in line, the caller joins after them
+	expired, err := passLapsedOffers(stub, show, sm, now)
+	if err != nil {
+		return shim.Error("Failed to join waitlist : " + err.Error())
+	}
+	if entry != nil && entry.Status == WaitlistWaiting {
+		expiredAsBytes, _ := json.Marshal(expired)
+		fmt.Println("- end join_waitlist, already waiting")
+		return shim.Success(expiredAsBytes)
+	}
+
+	entry = &WaitlistEntry{ObjectType: "WaitlistEntry", ShowId: show.ShowId, TheatreRegNo: show.TheatreRegNo, Owner: caller.OwnerId()}
+	entry.Seats = request.Seats
+	entry.Status = WaitlistWaiting
+	entry.JoinedAt = now.Format(waitlistTimeLayout)
+	errEntry := putEntity(stub, entry, keyWaitlist, entry.ShowId, entry.Owner)
+	if errEntry != nil {
+		return shim.Error("Failed to join waitlist : " + errEntry.Error())
+	}
+	errIdx := putIndex(stub, indexWaitlist, entry.ShowId, entry.JoinedAt, entry.Owner)
+	if errIdx != nil {
+		return shim.Error("Failed to join waitlist : " + errIdx.Error())
+	}
+
+	entryAsBytes, _ := json.Marshal(entry)
+	fmt.Println("- end join_waitlist")
+	return shim.Success(entryAsBytes)
+}
+
+// ============================================================================================================================
+// leave_waitlist() - stop waiting for seats of a show
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"showId":"value1"}
+// ============================================================================================================================
+func leave_waitlist(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting leave_waitlist")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	showId, _ := jsonValue["showId"].(string)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	entry, err := getWaitlistEntry(stub, showId, caller.OwnerId())
+	if err != nil {
+		return shim.Error("Failed to leave waitlist : " + err.Error())
+	}
+	if entry == nil || entry.Status != WaitlistWaiting {
+		return shim.Error("Not waiting for show " + showId)
+	}
+
+	errIdx := delIndex(stub, indexWaitlist, entry.ShowId, entry.JoinedAt, entry.Owner)
+	if errIdx != nil {
+		return shim.Error("Failed to leave waitlist : " + errIdx.Error())
+	}
+	entry.Status = WaitlistLeft
+	errEntry := putEntity(stub, entry, keyWaitlist, entry.ShowId, entry.Owner)
+	if errEntry != nil {
+		return shim.Error("Failed to leave waitlist : " + errEntry.Error())
+	}
+
+	entryAsBytes, _ := json.Marshal(entry)
+	fmt.Println("- end leave_waitlist")
+	return shim.Success(entryAsBytes)
+}
+
+// ============================================================================================================================
+// get_waitlist() - read the buyers waiting for a show in the order they are offered seats
+//
+// Staff of the theatre and platform admins read the whole line, buyers only their own entry.
+//
+// Inputs - JSON Object
+//    0
+//   json_object
+//  {"showId":"value1"}
+// ============================================================================================================================
+func get_waitlist(stub shim.ChaincodeStubInterface, args []string) pb.Response {
+	fmt.Println("starting get_waitlist")
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting 1")
+	}
+
+	var jsonValue map[string]interface{}
+	json.Unmarshal([]byte(args[0]), &jsonValue)
+	showId, _ := jsonValue["showId"].(string)
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	show, err := getShow(stub, showId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	entries, err := getWaitingEntries(stub, show.ShowId)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for i := range entries {
+		entries[i].Position = i + 1
+	}
+
+	staff := caller.Role == RolePlatformAdmin || (caller.Role != RoleCustomer && caller.TheatreRegNo == show.TheatreRegNo)
+	if !staff {
+		mine := []WaitlistEntry{}
+		for _, entry := range entries {
+			if entry.Owner == caller.OwnerId() {
+				mine = append(mine, entry)
+			}
+		}
+		if len(mine) == 0 {
+			entry, err := getWaitlistEntry(stub, show.ShowId, caller.OwnerId())
+			if err != nil {
+				return shim.Error(err.Error())
+			}
+			if entry != nil {
+				mine = append(mine, *entry)
+			}
+		}
+		entries = mine
+	}
+	if entries == nil {
+		entries = []WaitlistEntry{}
+	}
+	entriesAsBytes, _ := json.Marshal(entries)
+
+	fmt.Println("- end get_waitlist")
+	return shim.Success(entriesAsBytes)
+}
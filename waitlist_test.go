@@ -0,0 +1,90 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Seats of an offer left to expire go to the next buyer waiting, not to the first buyer to book them
+func TestExpiredOfferGoesToTheNextBuyer(t *testing.T) {
+	l := newTestLedger(t, 1, 2)
+	carol := benchIdentity("Org1MSP", "carol", map[string]string{"role": "customer"})
+	dave := benchIdentity("Org1MSP", "dave", map[string]string{"role": "customer"})
+	l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1"]}`)
+	var ticket Tickets
+	json.Unmarshal(l.ok(l.bob, "book_tickets", `{"showId":"S1","seats":["A2"]}`), &ticket)
+	l.ok(carol, "join_waitlist", `{"showId":"S1","seats":1}`)
+	l.now = l.now.Add(time.Second)
+	l.ok(dave, "join_waitlist", `{"showId":"S1","seats":1}`)
+
+	l.ok(l.bob, "cancel_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+	var offers WaitlistOffers
+	json.Unmarshal(l.events[len(l.events)-1].Payload, &offers)
+	carolHold := offers.Offers[0].HoldId
+
+	// the offer lapses, dave is still waiting and alice joins after him
+	l.now = l.now.Add(31 * time.Minute)
+	l.fail(l.alice, "book_tickets", `{"showId":"S1","seats":["A2"]}`)
+	l.fail(l.alice, "hold_seats", `{"showId":"S1","seats":["A2"]}`)
+	l.fail(l.alice, "expire_offers", `{"showId":"S1"}`)
+	l.ok(l.alice, "join_waitlist", `{"showId":"S1","seats":1}`)
+	json.Unmarshal(l.events[len(l.events)-1].Payload, &offers)
+	if len(offers.Offers) != 1 || offers.Offers[0].Seats[0] != "A2" || offers.Offers[0].HoldId == carolHold {
+		t.Fatalf("%+v", offers)
+	}
+	l.fail(carol, "confirm_hold", `{"holdId":"`+carolHold+`"}`)
+
+	// the offer of dave lapses too, alice still waiting passes it on to herself
+	l.now = l.now.Add(31 * time.Minute)
+	daveHold := offers.Offers[0].HoldId
+	l.fail(l.alice, "book_tickets", `{"showId":"S1","seats":["A2"]}`)
+	var expired []WaitlistEntry
+	json.Unmarshal(l.ok(l.alice, "join_waitlist", `{"showId":"S1","seats":1}`), &expired)
+	if len(expired) != 1 || expired[0].HoldId != daveHold || expired[0].Status != WaitlistExpired {
+		t.Fatalf("%+v", expired)
+	}
+	json.Unmarshal(l.events[len(l.events)-1].Payload, &offers)
+	l.ok(l.alice, "confirm_hold", `{"holdId":"`+offers.Offers[0].HoldId+`"}`)
+	l.fail(dave, "confirm_hold", `{"holdId":"`+daveHold+`"}`)
+
+	var entries []WaitlistEntry
+	json.Unmarshal(l.ok(carol, "get_waitlist", `{"showId":"S1"}`), &entries)
+	if len(entries) != 1 || entries[0].Status != WaitlistExpired {
+		t.Fatalf("%+v", entries)
+	}
+}
+
+// Staff pass on the offers left to expire, seats nobody in line takes are free for every booking
+func TestExpireOffersFreesSeatsNobodyWaitsFor(t *testing.T) {
+	l := newTestLedger(t, 1, 1)
+	var ticket Tickets
+	json.Unmarshal(l.ok(l.bob, "book_tickets", `{"showId":"S1","seats":["A1"]}`), &ticket)
+	l.ok(l.alice, "join_waitlist", `{"showId":"S1","seats":1}`)
+	l.ok(l.bob, "cancel_ticket", `{"ticketId":"`+ticket.TicketId+`"}`)
+
+	l.now = l.now.Add(31 * time.Minute)
+	var expired []WaitlistEntry
+	json.Unmarshal(l.ok(l.cashier, "expire_offers", `{"showId":"S1"}`), &expired)
+	if len(expired) != 1 || expired[0].Status != WaitlistExpired {
+		t.Fatalf("%+v", expired)
+	}
+	l.ok(l.bob, "book_tickets", `{"showId":"S1","seats":["A1"]}`)
+}
@@ -18,9 +18,11 @@ under the License.
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -54,9 +56,7 @@ func invoke_transaction_insert_update(stub shim.ChaincodeStubInterface, args []s
 	json.Unmarshal([]byte(value), &jsonValue)
 	key, _ = jsonValue["transactionGroupId"].(string)
 
-	valueAsBytes, _ := json.Marshal(jsonValue)
-
-	errPut := stub.PutState(key, valueAsBytes) //write the transaction into the ledger
+	errPut := putEntity(stub, jsonValue, keyTransaction, key) //write the transaction into the ledger
 	if errPut != nil {
 		return shim.Error("Failed to put state : " + errPut.Error())
 	}
@@ -89,17 +89,28 @@ func add_theatre(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var jsonValue map[string]interface{}
 	json.Unmarshal([]byte(value), &jsonValue)
 	key, _ = jsonValue["theatreRegNo"].(string)
+	timeZone, _ := jsonValue["timeZone"].(string)
+	if _, err := theatreLocation(timeZone); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	//check if theatre already exists
-	tr, _ := stub.GetState(key)
+	tr, _ := getEntity(stub, keyTheatre, key)
 	if tr != nil {
 		fmt.Println("This theatre already exists - " + key)
 		return shim.Error("This theatre already exists - " + key)
 	}
 
-	valueAsBytes, _ := json.Marshal(jsonValue)
+	// staff of the theatre are only accepted from its organisation, the platform's when not given
+	if mspId, _ := jsonValue["mspId"].(string); mspId == "" {
+		platform, err := getPlatform(stub)
+		if err != nil {
+			return shim.Error("Failed to add theatre : " + err.Error())
+		}
+		jsonValue["mspId"] = platform.MspId
+	}
 
-	errPut := stub.PutState(key, valueAsBytes) //write the theatre details into the ledger
+	errPut := putEntity(stub, jsonValue, keyTheatre, key) //write the theatre details into the ledger
 	if errPut != nil {
 		return shim.Error("Failed to add theatre : " + errPut.Error())
 	}
@@ -116,14 +127,14 @@ func add_theatre(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 // Inputs - JSON Object
 //    0
 //   json_object
-//  {"movieId":"value1","movieName":"value2","docType":"value3"}
+//  {"movieId":"value1","movieName":"value2","format":"3D","docType":"value3"}
 // ============================================================================================================================
 func add_movies(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var key, theatreRegNo, value string
 	// var err error
 	fmt.Println("starting add_movies")
 
-	certname, err := get_cert(stub)
+	caller, err := get_caller(stub)
 	if err != nil {
 		fmt.Printf("INVOKE: Error retrieving cert: %s", err)
 		return shim.Error("Error retrieving cert")
@@ -138,8 +149,16 @@ func add_movies(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var jsonValue map[string]interface{}
 	json.Unmarshal([]byte(value), &jsonValue)
 	key, _ = jsonValue["movieId"].(string)
-	theatreRegNo = string(certname)
+	theatreRegNo = caller.TheatreRegNo
 	movieName, _ := jsonValue["movieName"].(string)
+	format, _ := jsonValue["format"].(string)
+	if format == "" {
+		format = "2D"
+	}
+	runtime, _ := jsonValue["runtimeMinutes"].(float64)
+	if runtime < 0 || runtime > 24*60 {
+		return shim.Error("runtimeMinutes must be between 0 and 1440, 0 takes the default runtime")
+	}
 
 	// Create Movie Object
 	var mov Movies
@@ -147,9 +166,20 @@ func add_movies(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	mov.MovieId = key
 	mov.MovieName = movieName
 	mov.TheatreRegNo = theatreRegNo
+	mov.Format = format
+	mov.RuntimeMinutes = int(runtime)
+	mov.ReleaseDate, _ = jsonValue["releaseDate"].(string)
+	mov.EndDate, _ = jsonValue["endDate"].(string)
+
+	//check if movie already exists, ended and removed movies keep their record
+	movieAsBytes, _ := getEntity(stub, keyMovie, theatreRegNo, key)
+	if movieAsBytes != nil {
+		fmt.Println("This movie already exists - " + key)
+		return shim.Error("This movie already exists - " + key)
+	}
 
 	//check if theatre exists or not
-	theatreAsBytes, _ := stub.GetState(theatreRegNo)
+	theatreAsBytes, _ := getEntity(stub, keyTheatre, theatreRegNo)
 	if theatreAsBytes == nil {
 		fmt.Println("This theatre does not exists - " + theatreRegNo)
 		return shim.Error("This theatre does not exists - " + theatreRegNo)
@@ -157,24 +187,51 @@ func add_movies(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	theatre := Theatre{}
 	json.Unmarshal(theatreAsBytes, &theatre) //un stringify it aka JSON.parse()
 
+	// movies sent without a runtime run for the default runtime of the config
+	if mov.RuntimeMinutes == 0 {
+		cfg, err := getConfig(stub, theatreRegNo)
+		if err != nil {
+			return shim.Error("Failed to add movies : " + err.Error())
+		}
+		mov.RuntimeMinutes = cfg.DefaultRuntimeMinutes
+	}
+
 	// check movies when it will be releasing
-	mov.Status = "Running"
-	if len(theatre.MoviesRunning) < theatre.NumberOfScreens {
-		theatre.MoviesRunning = append(theatre.MoviesRunning, mov)
+	loc, err := theatreLocation(theatre.TimeZone)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to add movies : " + err.Error())
+	}
+	if mov.ReleaseDate == "" {
+		mov.ReleaseDate = now.In(loc).Format("2006-01-02")
+	}
+	release, end, err := movieWindow(mov, loc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !end.IsZero() && !end.After(release) {
+		return shim.Error("endDate cannot be before releaseDate for movie " + mov.MovieId)
+	}
+	mov.Status, _ = movieStatus(mov, now, loc)
+	if mov.Status == MovieEnded {
+		return shim.Error("The run of this movie has already ended - " + mov.EndDate)
+	}
+	// any number of movies can run, add_shows finds them a free screen
+	if mov.Status == MovieComingSoon {
+		theatre.MoviesComingSoon = append(theatre.MoviesComingSoon, mov)
 	} else {
-		return shim.Error("Only " + strconv.Itoa(theatre.NumberOfScreens) + " movies can run for this theatre " + theatreRegNo)
+		theatre.MoviesRunning = append(theatre.MoviesRunning, mov)
 	}
 
-	trAsBytes, _ := json.Marshal(theatre)
-
-	errTr := stub.PutState(theatreRegNo, trAsBytes) // update the theatre details into the ledger
+	errTr := putEntity(stub, theatre, keyTheatre, theatreRegNo) // update the theatre details into the ledger
 	if errTr != nil {
 		return shim.Error("Failed to add movies : " + errTr.Error())
 	}
 
-	valueAsBytes, _ := json.Marshal(mov)
-
-	errPut := stub.PutState(key, valueAsBytes) //write the movie details into the ledger
+	errPut := putEntity(stub, mov, keyMovie, theatreRegNo, key) //write the movie details into the ledger
 	if errPut != nil {
 		return shim.Error("Failed to add movies : " + errPut.Error())
 	}
@@ -198,7 +255,7 @@ func add_shows(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	// var err error
 	fmt.Println("starting add_shows")
 
-	certname, err := get_cert(stub)
+	caller, err := get_caller(stub)
 	if err != nil {
 		fmt.Printf("INVOKE: Error retrieving cert: %s", err)
 		return shim.Error("Error retrieving cert")
@@ -207,9 +264,9 @@ func add_shows(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	if len(args) < 1 {
 		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
 	}
-	theatreRegNo = string(certname)
+	theatreRegNo = caller.TheatreRegNo
 	//check if theatre exists or not
-	theatreAsBytes, _ := stub.GetState(theatreRegNo)
+	theatreAsBytes, _ := getEntity(stub, keyTheatre, theatreRegNo)
 	if theatreAsBytes == nil {
 		fmt.Println("Only theatres can add shows for a movie - " + theatreRegNo)
 		return shim.Error("Only theatres can add shows for a movie - " + theatreRegNo)
@@ -221,27 +278,38 @@ func add_shows(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var show Shows
 	json.Unmarshal([]byte(value), &show)
 	// show.ObjectType = "Shows"
-	show.TotalSeat = 100
-	show.AvailableSeat = 100
 	show.BookedSeat = 0
-	show.ShowStatus = "Running"
+	show.ShowStatus = ShowRunning
 	show.TheatreRegNo = theatreRegNo
-	show.ShowDate = show.ShowTiming[:10]
-	if strings.HasSuffix(show.ShowTiming, "am") {
-		show.PricePerTicket = 100
-	} else {
-		show.PricePerTicket = 180
+
+	loc, err := theatreLocation(ttr.TimeZone)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	start, err := parseShowTiming(show.ShowTiming, loc)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to add shows : " + err.Error())
 	}
+	if !start.After(now) {
+		fmt.Println("Shows cannot be added in the past - " + show.ShowTiming)
+		return shim.Error("Shows cannot be added in the past - " + show.ShowTiming)
+	}
+	show.ShowStart = start.Format(time.RFC3339)
+	show.ShowDate = start.Format("2006-01-02")
 
 	//check if show already exists
-	sw, _ := stub.GetState(show.ShowId)
+	sw, _ := getEntity(stub, keyShow, show.ShowId)
 	if sw != nil {
 		fmt.Println("This show already exists - " + show.ShowId)
 		return shim.Error("This show already exists - " + show.ShowId)
 	}
 
 	//check if theatre exists or not
-	movieAsBytes, _ := stub.GetState(show.MovieId)
+	movieAsBytes, _ := getEntity(stub, keyMovie, theatreRegNo, show.MovieId)
 	if movieAsBytes == nil {
 		fmt.Println("Only theatres can add shows for a movie - " + theatreRegNo)
 		return shim.Error("Only theatres can add shows for a movie - " + theatreRegNo)
@@ -253,107 +321,284 @@ func add_shows(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 		fmt.Println("You cannot add a show for a movie which is not running in - " + theatreRegNo)
 		return shim.Error("You cannot add a show for a movie which is not running in - " + theatreRegNo)
 	}
+	err = checkRunWindow(mov, start, loc)
+	if err != nil {
+		fmt.Println(err.Error())
+		return shim.Error(err.Error())
+	}
 
-	screenNumber := screenAvailable(ttr.NumberOfScreens, show.ShowTiming, show.ShowDate, show.MovieId, stub)
-	if screenNumber == 0 {
-		fmt.Println("All the screens are full for this show timing or this movie is already running for the show timing on another screen. Please select different time for show")
-		return shim.Error("All the screens are full for this show timing or this movie is already running for the show timing on another screen. Please select different time for show")
-	} else if screenNumber == 20 {
-		fmt.Println("Only 4 shows are allowed for a day for a particular movie")
-		return shim.Error("Only 4 shows are allowed for a day for a particular movie")
+	cfg, err := getConfig(stub, theatreRegNo)
+	if err != nil {
+		return shim.Error("Failed to add shows : " + err.Error())
+	}
+	screens, err := getTheatreScreens(stub, ttr, cfg)
+	if err != nil {
+		return shim.Error("Failed to add shows : " + err.Error())
 	}
-	show.ScreenNumber = screenNumber
-	showAsBytes, _ := json.Marshal(show)
+	runtime := mov.RuntimeMinutes
+	if runtime <= 0 {
+		runtime = cfg.DefaultRuntimeMinutes
+	}
+	show.ShowEnd = start.Add(time.Duration(runtime) * time.Minute).Format(time.RFC3339)
+	screen, err := screenAvailable(screens, show, mov, cfg, stub)
+	if err != nil {
+		fmt.Println(err.Error())
+		return shim.Error(err.Error())
+	}
+	show.ScreenNumber = screen.ScreenNumber
+	show.ScreenName = screen.ScreenName
+	show.TotalSeat = screen.Rows * screen.Columns
+	show.AvailableSeat = show.TotalSeat
+
+	// price of a standard seat, booking works out the price of each seat
+	plan, err := getPricePlan(stub, theatreRegNo)
+	if err != nil {
+		return shim.Error("Failed to add shows : " + err.Error())
+	}
+	show.PricePerTicket = priceSeat(plan, start, "", defaultSeatCategory, mov.Format).Price
 
-	errShw := stub.PutState(show.ShowId, showAsBytes) // update the theatre details into the ledger
+	errShw := putEntity(stub, show, keyShow, show.ShowId) // write the show details into the ledger
 	if errShw != nil {
 		return shim.Error("Failed to add shows : " + errShw.Error())
 	}
 
-	var acc Accessories
-	acc.ObjectType = "Accessories"
-	acc.Asset = "Soda"
-	acc.TotalQty = 200
-	acc.ForDate = show.ShowDate
-	acc.AvailableQty = 200
-
-	//check if Accessories already exists
-	access, _ := stub.GetState(acc.ForDate)
-	if access == nil {
-		accAsBytes, _ := json.Marshal(acc)
-		errAcc := stub.PutState(acc.ForDate, accAsBytes) // update the theatre details into the ledger
-		if errAcc != nil {
-			return shim.Error("Failed to add shows : " + errAcc.Error())
-		}
+	errIdx := putIndex(stub, indexTheatreShow, theatreRegNo, show.ShowDate, show.ShowId)
+	if errIdx != nil {
+		return shim.Error("Failed to add shows : " + errIdx.Error())
+	}
+
+	errSm := putSeatMap(stub, newSeatMap(show.ShowId, screen.ScreenLayout)) // seat map of the show
+	if errSm != nil {
+		return shim.Error("Failed to add shows : " + errSm.Error())
 	}
 
 	fmt.Println("- end add_shows")
 	return shim.Success(nil)
 }
 
+// Checks bookings are open for a show - not cancelled, not started and inside the booking
+// window of the movie. Gives the movie and the start of the show.
+func checkBookable(stub shim.ChaincodeStubInterface, show Shows, now time.Time) (Movies, time.Time, error) {
+	mov := Movies{}
+	start, err := showStartTime(show.ShowStart, show.ShowTiming)
+	if err != nil {
+		return mov, start, err
+	}
+	if !now.Before(start) {
+		return mov, start, errors.New("Bookings are closed as the show has already started - " + show.ShowId)
+	}
+	if show.ShowStatus == ShowCancelled {
+		return mov, start, errors.New("This show has been cancelled - " + show.ShowId)
+	}
+	movieAsBytes, _ := getEntity(stub, keyMovie, show.TheatreRegNo, show.MovieId)
+	json.Unmarshal(movieAsBytes, &mov)
+
+	// bookings open preBookingDays before the release of the movie
+	cfg, err := getConfig(stub, show.TheatreRegNo)
+	if err != nil {
+		return mov, start, err
+	}
+	err = checkBookingWindow(mov, now, start.Location(), cfg)
+	return mov, start, err
+}
+
+// Longest requestId accepted by book_tickets
+const maxRequestIdLength = 64
+
+// Hash of what a booking request asks for, a retry must send the same show and seats
+func bookingRequestHash(ticket Tickets) string {
+	digest := sha256.Sum256([]byte(ticket.ShowId + "\x00" + strings.Join(ticket.Seats, ",")))
+	return hex.EncodeToString(digest[:])
+}
+
+// Reads the ticket already booked by the owner with the requestId of the ticket, nil when the
+// requestId is new. Fails when the requestId was used to book other seats.
+func getRequestTicket(stub shim.ChaincodeStubInterface, owner string, ticket Tickets) ([]byte, error) {
+	if len(ticket.RequestId) > maxRequestIdLength {
+		return nil, errors.New("requestId can have at most " + strconv.Itoa(maxRequestIdLength) + " characters")
+	}
+	entries, err := getIndex(stub, indexRequest, owner, ticket.RequestId)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	if entries[0][2] != bookingRequestHash(ticket) {
+		return nil, errors.New("requestId " + ticket.RequestId + " was already used to book other seats - ticket " + entries[0][3])
+	}
+	return getEntity(stub, keyTicket, entries[0][3])
+}
+
+// Sells the seats of a ticket and writes the ticket, its indexes and the seats sold. The show is
+// only read, so bookings of different seats of a show touch no common key and commit together.
+// Seats of the hold count as free for the ticket, which is sold at the price the hold quoted.
+// countedFor is the buyer whose purchase counters hold the ticket, empty for the box office.
+func issueTicket(stub shim.ChaincodeStubInterface, show Shows, mov Movies, ticket Tickets, countedFor string, sm SeatMap, hold SeatHold, now time.Time, start time.Time) (Tickets, error) {
+	ticket.ObjectType = "Tickets"
+	ticket.TicketId = "T" + txScopedId(stub) // short enough for read, the show is on the ticket
+	ticket.ShowId = show.ShowId
+	ticket.NumberOfTickets = len(ticket.Seats)
+	ticket.MovieName = mov.MovieName
+	ticket.ShowTiming = show.ShowTiming
+	ticket.ShowStart = show.ShowStart
+	ticket.TheatreRegNo = show.TheatreRegNo
+	ticket.ScreenNumber = show.ScreenNumber
+	ticket.Status = TicketBooked
+	ticket.CountedFor = countedFor
+	ticket.CountedDate = ""
+	if countedFor != "" {
+		ticket.CountedDate = show.ShowDate
+	}
+
+	err := sellSeats(&sm, ticket.Seats, ticket.TicketId, hold.HoldId, now)
+	if err != nil {
+		return ticket, err
+	}
+
+	ticket.TotalPrice = hold.TotalPrice
+	ticket.PriceBreakdown = hold.PriceBreakdown
+	if hold.HoldId == "" {
+		plan, err := getPricePlan(stub, show.TheatreRegNo)
+		if err != nil {
+			return ticket, err
+		}
+		for _, seat := range sm.Seats {
+			if seat.TicketId == ticket.TicketId {
+				price := priceSeat(plan, start, seat.SeatId, seat.Category, mov.Format)
+				ticket.PriceBreakdown = append(ticket.PriceBreakdown, price)
+				ticket.TotalPrice += price.Price
+			}
+		}
+	}
+	ticket.Amenities = nil // one per seat sold, whatever the client sent
+	for _, seatId := range ticket.Seats {
+		var amn Amenities
+		amn.SeatNumber = seatId
+		amn.PopCorn = 1
+		amn.Water = 1
+		ticket.Amenities = append(ticket.Amenities, amn)
+	}
+	ticket.VerifyCode = verificationCode(ticket)
+
+	err = putEntity(stub, ticket, keyTicket, ticket.TicketId) // write the ticket details into the ledger
+	if err != nil {
+		return ticket, err
+	}
+	err = putIndex(stub, indexShowTicket, ticket.ShowId, ticket.TicketId)
+	if err != nil {
+		return ticket, err
+	}
+	err = putIndex(stub, indexOwnerTicket, ticket.Owner, start.UTC().Format(time.RFC3339), ticket.TicketId)
+	if err != nil {
+		return ticket, err
+	}
+	return ticket, putSeats(stub, sm)
+}
+
 // ============================================================================================================================
 // book_tickets() - Buy Movie Tickets and record into ledger
 //
 // Shows Off PutState() - writting a key/value into the ledger
 //
+// requestId is optional. Sent again with the same show and seats it gives back the ticket booked the first time.
+//
 // Inputs - JSON Object
 //    0
 //   json_object
-//  {"showId":"value1","numberOfTickets":"value2"}
+//  {"showId":"value1","seats":["A1","A2"],"requestId":"value2"}
 // ============================================================================================================================
 func book_tickets(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 	var value string
-	var ticketAsBytes []byte
-	// var err error
 	fmt.Println("starting book_tickets")
 
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
 	value = args[0]
-	var ticket Tickets
-	json.Unmarshal([]byte(value), &ticket)
-	shAsBytes, _ := stub.GetState(ticket.ShowId)
+	var request struct {
+		ShowId    string   `json:"showId"`
+		Seats     []string `json:"seats"`
+		RequestId string   `json:"requestId"`
+	}
+	json.Unmarshal([]byte(value), &request)
+
+	// only what the buyer chooses is read, the rest of the ticket is set here
+	ticket := Tickets{ShowId: request.ShowId, Seats: request.Seats, RequestId: request.RequestId}
+	shAsBytes, _ := getEntity(stub, keyShow, ticket.ShowId)
+	if shAsBytes == nil {
+		return shim.Error("This show does not exists - " + ticket.ShowId)
+	}
 	show := Shows{}
 	json.Unmarshal(shAsBytes, &show)
-	if show.AvailableSeat == 0 {
-		return shim.Error("Failed to book tickets for show as no seats are available.")
-	} else if ticket.NumberOfTickets <= show.AvailableSeat {
-		movieAsBytes, _ := stub.GetState(show.MovieId)
-		mov := Movies{}
-		json.Unmarshal(movieAsBytes, &mov)
-		rand.Seed(time.Now().UnixNano())
-
-		ticket.ObjectType = "Tickets"
-		ticket.TicketId = "T" + show.TheatreRegNo + show.ShowId + strconv.Itoa(rand.Intn(1000000))
-		ticket.MovieName = mov.MovieName
-		ticket.ShowTiming = show.ShowTiming
-		ticket.TotalPrice = show.PricePerTicket * ticket.NumberOfTickets
-		ticket.ScreenNumber = show.ScreenNumber
-		show.BookedSeat += ticket.NumberOfTickets
-		show.AvailableSeat -= ticket.NumberOfTickets
-
-		for i := 1; i <= ticket.NumberOfTickets; i++ {
-			var amn Amenities
-			amn.SeatNumber = "S" + show.TheatreRegNo + show.ShowId + strconv.Itoa(rand.Intn(100000))
-			amn.PopCorn = 1
-			amn.Water = 1
-			ticket.Amenities = append(ticket.Amenities, amn)
-		}
+	ticket.NumberOfTickets = len(ticket.Seats)
 
-		ticketAsBytes, _ = json.Marshal(ticket)
-		errTkt := stub.PutState(ticket.TicketId, ticketAsBytes) // update the theatre details into the ledger
-		if errTkt != nil {
-			return shim.Error("Failed to book tickets : " + errTkt.Error())
-		}
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+	if caller.Role == RoleCashier && caller.TheatreRegNo != show.TheatreRegNo {
+		return codedError(ErrAccessDenied, "Cashiers can only book tickets for shows of "+caller.TheatreRegNo)
+	}
 
-		showAsBytes, _ := json.Marshal(show)
-		errShow := stub.PutState(show.ShowId, showAsBytes) // update the theatre details into the ledger
-		if errShow != nil {
-			return shim.Error("Failed to book tickets : " + errShow.Error())
+	// a retry of a request already booked gives back its ticket without booking again
+	if ticket.RequestId != "" {
+		ticketAsBytes, err := getRequestTicket(stub, caller.OwnerId(), ticket)
+		if err != nil {
+			return shim.Error("Failed to book tickets : " + err.Error())
+		}
+		if ticketAsBytes != nil {
+			fmt.Println("- end book_tickets, request " + ticket.RequestId + " already booked")
+			return shim.Success(ticketAsBytes)
 		}
+	}
 
-	} else {
-		return shim.Error("Failed to book tickets for shows as only " + strconv.Itoa(show.AvailableSeat) + " seats are available.")
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to book tickets : " + err.Error())
+	}
+	mov, start, err := checkBookable(stub, show, now)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	// seats left are not counted here, reading every seat would conflict with the other bookings
+	sm, err := getSeats(stub, show.ShowId, ticket.Seats)
+	if err == nil {
+		err = checkLapsedOffers(stub, show, sm, now)
+	}
+	if err != nil {
+		return shim.Error("Failed to book tickets : " + err.Error())
+	}
+
+	// box office sales are only held to the limit of one transaction
+	cfg, err := getConfig(stub, show.TheatreRegNo)
+	if err != nil {
+		return shim.Error("Failed to book tickets : " + err.Error())
+	}
+	err = checkTransactionLimit(cfg, len(ticket.Seats))
+	if err == nil && caller.Role != RoleCashier {
+		err = addPurchase(stub, cfg, caller.OwnerId(), show, len(ticket.Seats))
+	}
+	if err != nil {
+		return purchaseError("Failed to book tickets : ", err)
 	}
 
+	ticket.Owner = caller.OwnerId()
+	countedFor := ""
+	if caller.Role != RoleCashier {
+		countedFor = ticket.Owner
+	}
+	ticket, err = issueTicket(stub, show, mov, ticket, countedFor, sm, SeatHold{}, now, start)
+	if err != nil {
+		return shim.Error("Failed to book tickets : " + err.Error())
+	}
+	if ticket.RequestId != "" {
+		errIdx := putIndex(stub, indexRequest, ticket.Owner, ticket.RequestId, bookingRequestHash(ticket), ticket.TicketId)
+		if errIdx != nil {
+			return shim.Error("Failed to book tickets : " + errIdx.Error())
+		}
+	}
+	ticketAsBytes, _ := json.Marshal(ticket)
+
 	fmt.Println("- end book_tickets")
 	return shim.Success(ticketAsBytes)
 }
@@ -361,129 +606,222 @@ func book_tickets(stub shim.ChaincodeStubInterface, args []string) pb.Response {
 // ============================================================================================================================
 // exchange_water() - Exchange Water with Soda and record into ledger
 //
-// Shows Off PutState() - writting a key/value into the ledger
+// Sodas are granted first come first served from the Accessories quota of the day, in the order the
+// exchanges commit. When fewer sodas are left than seats asked for, the seats are exchanged in the
+// order given until the quota runs out. Leaving out "seats" exchanges every seat of the ticket.
 //
 // Inputs - JSON Object
 //    0
 //   json_object
-//  {"ticketId":"value1"}
+//  {"ticketId":"value1","seats":["A1","A2"]}
 // ============================================================================================================================
 func exchange_water(stub shim.ChaincodeStubInterface, args []string) pb.Response {
-	var ticketId, value string
+	var value string
 	// var err error
 	fmt.Println("starting exchange_water")
-	rand.Seed(time.Now().UnixNano())
-	randNo := rand.Intn(200)
-	fmt.Println("randNo ====> ")
-	fmt.Println(randNo)
-
-	if randNo%2 == 0 {
-		value = args[0]
-		var jsonValue map[string]interface{}
-		json.Unmarshal([]byte(value), &jsonValue)
-		ticketId, _ = jsonValue["ticketId"].(string)
-
-		tktAsBytes, _ := stub.GetState(ticketId)
-		ticket := Tickets{}
-		json.Unmarshal(tktAsBytes, &ticket)
-		forDate := ticket.ShowTiming[:10]
-
-		accAsBytes, _ := stub.GetState(forDate)
-		acc := Accessories{}
-		json.Unmarshal(accAsBytes, &acc)
-		fmt.Println(acc)
-		if ticket.NumberOfTickets <= acc.AvailableQty {
-			for i, amn := range ticket.Amenities {
+
+	if len(args) < 1 {
+		return shim.Error("Incorrect number of arguments. Expecting Minimum 1. arguments of the variable and value to set")
+	}
+
+	caller, err := get_caller(stub)
+	if err != nil {
+		return shim.Error("Error retrieving cert")
+	}
+
+	value = args[0]
+	var request Tickets
+	json.Unmarshal([]byte(value), &request)
+
+	tktAsBytes, _ := getEntity(stub, keyTicket, request.TicketId)
+	if tktAsBytes == nil {
+		return shim.Error("This ticket does not exists - " + request.TicketId)
+	}
+	ticket := Tickets{}
+	json.Unmarshal(tktAsBytes, &ticket)
+	if ticket.Owner != caller.OwnerId() {
+		return codedError(ErrAccessDenied, "Only the owner of the ticket can exchange water for it - "+ticket.TicketId)
+	}
+	if ticket.Status == TicketCancelled {
+		return shim.Error("Soda cannot be exchanged for a cancelled ticket - " + ticket.TicketId)
+	}
+
+	// seats asked for, in order, that still hold water
+	var pending []int
+	for _, seatId := range request.Seats {
+		found := false
+		for i, amn := range ticket.Amenities {
+			if amn.SeatNumber == seatId {
+				found = true
 				if amn.Soda == 1 {
-					return shim.Error("Soda has already been exchanged for this ticket.")
+					return shim.Error("Soda has already been exchanged for seat " + seatId)
 				}
-				amn.Water = 0
-				amn.Soda = 1
-				ticket.Amenities[i] = amn
-				// fmt.Println(ticket.Amenities[i])
+				pending = append(pending, i)
 			}
-			acc.AvailableQty -= ticket.NumberOfTickets
-			ticketAsBytes, _ := json.Marshal(ticket)
-			errTkt := stub.PutState(ticketId, ticketAsBytes) // update the theatre details into the ledger
-			if errTkt != nil {
-				return shim.Error("Failed to exchange_water : " + errTkt.Error())
+		}
+		if !found {
+			return shim.Error("Seat " + seatId + " is not part of ticket " + ticket.TicketId)
+		}
+	}
+	if len(request.Seats) == 0 {
+		for i, amn := range ticket.Amenities {
+			if amn.Soda == 0 {
+				pending = append(pending, i)
 			}
-			fmt.Println(acc)
+		}
+	}
+	if len(pending) == 0 {
+		return shim.Error("Soda has already been exchanged for this ticket.")
+	}
 
-			accessAsBytes, _ := json.Marshal(acc)
-			errAcc := stub.PutState(forDate, accessAsBytes) // update the theatre details into the ledger
-			if errAcc != nil {
-				return shim.Error("Failed to exchange_water : " + errAcc.Error())
-			}
+	// the quota resets every day, the day being the business date of this transaction at the theatre
+	now, err := txTime(stub)
+	if err != nil {
+		return shim.Error("Failed to exchange_water : " + err.Error())
+	}
+	start, err := showStartTime(ticket.ShowStart, ticket.ShowTiming)
+	if err != nil {
+		return shim.Error("Failed to exchange_water : " + err.Error())
+	}
+	forDate := now.In(start.Location()).Format("2006-01-02")
+
+	acc := Accessories{}
+	accAsBytes, _ := getEntity(stub, keyAccessories, "Soda", ticket.TheatreRegNo, forDate)
+	if accAsBytes == nil {
+		cfg, err := getConfig(stub, ticket.TheatreRegNo)
+		if err != nil {
+			return shim.Error("Failed to exchange_water : " + err.Error())
+		}
+		acc.ObjectType = "Accessories"
+		acc.Asset = "Soda"
+		acc.TheatreRegNo = ticket.TheatreRegNo
+		acc.TotalQty = cfg.SodaPerDay
+		acc.ForDate = forDate
+		acc.AvailableQty = cfg.SodaPerDay
+	} else {
+		json.Unmarshal(accAsBytes, &acc)
+	}
 
+	granted := grantQuota(&acc, len(pending))
+	if granted == 0 {
+		fmt.Println("Soda is out of stock.")
+		return codedError(ErrQuotaExhausted, "Soda is out of stock for "+forDate)
+	}
+
+	var result ExchangeResult
+	result.TicketId = ticket.TicketId
+	for n, i := range pending {
+		if n < granted {
+			ticket.Amenities[i].Water = 0
+			ticket.Amenities[i].Soda = 1
+			result.Exchanged = append(result.Exchanged, ticket.Amenities[i].SeatNumber)
 		} else {
-			fmt.Println("Soda is out of stock.")
-			return shim.Error("Soda is out of stock.")
+			result.NotExchanged = append(result.NotExchanged, ticket.Amenities[i].SeatNumber)
 		}
-	} else {
-		fmt.Println("Exchanging Water with Soda is currently not possible.")
-		return shim.Error("Exchanging Water with Soda is currently not possible.")
 	}
+	result.AvailableQty = acc.AvailableQty
 
+	errTkt := putEntity(stub, ticket, keyTicket, ticket.TicketId) // update the ticket details into the ledger
+	if errTkt != nil {
+		return shim.Error("Failed to exchange_water : " + errTkt.Error())
+	}
+
+	errAcc := putEntity(stub, acc, keyAccessories, acc.Asset, acc.TheatreRegNo, acc.ForDate) // update the soda quota into the ledger
+	if errAcc != nil {
+		return shim.Error("Failed to exchange_water : " + errAcc.Error())
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
 	fmt.Println("- end exchange_water")
-	return shim.Success(nil)
+	return shim.Success(resultAsBytes)
 }
 
-// Assigns screen number for a particular show
-func screenAvailable(noOfScreen int, showTiming string, showDate string, movieId string, stub shim.ChaincodeStubInterface) int {
-	// Compares whether a movie is not running more than 4 times a day.
-	queryFrmDate := `{"selector":{"docType":"Shows", "showDate":"` + showDate + `"}}`
-	queryStringDate := fmt.Sprintf(queryFrmDate)
-
-	queryFrm := `{"selector":{"docType":"Shows", "showTiming":"` + showTiming + `"}}`
-	queryString := fmt.Sprintf(queryFrm)
-	var screenNumber int
-	var arrayOfScreensUsed []int
-	var totalScreens []int
-	var unique []int
-	showsPerDay := 1
-	for i := 1; i <= noOfScreen; i++ {
-		totalScreens = append(totalScreens, i)
+// Takes up to requested units from the quota, returns how many were granted
+func grantQuota(acc *Accessories, requested int) int {
+	granted := requested
+	if granted > acc.AvailableQty {
+		granted = acc.AvailableQty
 	}
+	if granted < 0 {
+		granted = 0
+	}
+	acc.AvailableQty -= granted
+	acc.GrantedQty += granted
+	return granted
+}
 
-	queryResultsDate, _ := getQueryResultForQueryString(stub, queryStringDate)
-	var arrayOfShowsDate []Shows
-	json.Unmarshal(queryResultsDate, &arrayOfShowsDate)
-	if len(arrayOfShowsDate) > 0 {
-		for _, eachShowDate := range arrayOfShowsDate {
-			if showDate == eachShowDate.ShowDate && movieId == eachShowDate.MovieId {
-				showsPerDay += 1
-			}
+// Assigns a screen for a particular show, fails when no screen can take it. A screen is
+// taken from the start of a show until its end plus the cleaning buffer of the theatre,
+// a movie can run on as many screens at once as are free.
+func screenAvailable(screens []Screen, show Shows, movie Movies, cfg Config, stub shim.ChaincodeStubInterface) (Screen, error) {
+	showsPerDay := 1
+
+	// Shows of the theatre for the day, read through the theatre~date~show index
+	arrayOfShowsDate, _ := getTheatreShows(stub, show.TheatreRegNo, show.ShowDate)
+
+	// Compares whether a movie is not running more than the configured shows a day.
+	for _, eachShowDate := range arrayOfShowsDate {
+		if eachShowDate.ShowId == show.ShowId || eachShowDate.ShowStatus == ShowCancelled {
+			continue
 		}
-		if showsPerDay > 4 {
-			return 20 // Maximum Shows for a particular movie reached
+		if show.ShowDate == eachShowDate.ShowDate && movie.MovieId == eachShowDate.MovieId {
+			showsPerDay += 1
 		}
 	}
+	if showsPerDay > cfg.MaxShowsPerMoviePerDay {
+		return Screen{}, errors.New("Only " + strconv.Itoa(cfg.MaxShowsPerMoviePerDay) + " shows are allowed for a day for a particular movie")
+	}
+
+	start, end, err := showOccupancy(show, cfg)
+	if err != nil {
+		return Screen{}, err
+	}
 
-	// Assigns screens for a particular show for a movie.
-	queryResults, _ := getQueryResultForQueryString(stub, queryString)
-	var arrayOfShows []Shows
-	json.Unmarshal(queryResults, &arrayOfShows)
-	if len(arrayOfShows) > 0 {
-		for _, eachShow := range arrayOfShows {
-			arrayOfScreensUsed = append(arrayOfScreensUsed, eachShow.ScreenNumber)
+	// Shows running into the day or running on from it can overlap as well
+	nearbyShows, err := getShowsAround(stub, show.TheatreRegNo, start)
+	if err != nil {
+		return Screen{}, err
+	}
 
-			if eachShow.MovieId == movieId && eachShow.ShowTiming == showTiming {
-				return 0
-			}
+	// Screens busy at some point while the new show runs.
+	screensUsed := make(map[int]string)
+	for _, eachShow := range nearbyShows {
+		if eachShow.ShowId == show.ShowId || eachShow.ShowStatus == ShowCancelled {
+			continue
 		}
-		if len(arrayOfScreensUsed) >= noOfScreen {
-			return 0
-		} else {
-			unique = Difference(totalScreens, arrayOfScreensUsed)
-			for _, val := range unique {
-				return val
-			}
+		eachStart, eachEnd, err := showOccupancy(eachShow, cfg)
+		if err != nil {
+			return Screen{}, err
 		}
-	} else {
-		screenNumber = 1
+		if start.Before(eachEnd) && eachStart.Before(end) {
+			screensUsed[eachShow.ScreenNumber] = eachShow.ShowId
+		}
+	}
+
+	// Assigns the screen asked for, or else the first free screen which can play the format of the movie.
+	supported := false
+	for _, screen := range screens {
+		if show.ScreenNumber > 0 && screen.ScreenNumber != show.ScreenNumber {
+			continue
+		}
+		if !screen.supports(movie.Format) {
+			continue
+		}
+		supported = true
+		if _, used := screensUsed[screen.ScreenNumber]; !used {
+			return screen, nil
+		}
+	}
+	if !supported && show.ScreenNumber > 0 {
+		return Screen{}, errors.New("Screen " + strconv.Itoa(show.ScreenNumber) + " of theatre " + show.TheatreRegNo + " does not exist or does not support the " + movie.Format + " format")
+	}
+	if !supported {
+		return Screen{}, errors.New("No screen of theatre " + show.TheatreRegNo + " supports the " + movie.Format + " format")
+	}
+	if show.ScreenNumber > 0 {
+		return Screen{}, errors.New("Screen " + strconv.Itoa(show.ScreenNumber) + " is taken by show " + screensUsed[show.ScreenNumber] + ". Please select different time or screen for show")
 	}
-	return screenNumber
+	return Screen{}, errors.New("All the screens are taken by other shows between " + start.Format("2006-01-02 15:04") + " and " + end.Format("15:04") + ". Please select different time for show")
 }
 
 //Check Whether Current Date greater than or equal to Relase Date
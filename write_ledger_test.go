@@ -0,0 +1,98 @@
+/*
+Licensed to the Apache Software Foundation (ASF) under one
+or more contributor license agreements.  See the NOTICE file
+distributed with this work for additional information
+regarding  ownership.  The ASF licenses this file
+to you under the Apache License, Version 2.0 (the
+"License"); you may not use this file except in compliance
+with the License.  You may obtain a copy of the License at
+  http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing,
+software distributed under the License is distributed on an
+"AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+KIND, either express or implied.  See the License for the
+specific language governing permissions and limitations
+under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Sodas are granted once per seat of the ticket until the quota of the day runs out
+func TestExchangeWaterQuota(t *testing.T) {
+	l := newTestLedger(t, 10, 10)
+	l.ok(l.admin, "set_config", `{"config":{"sodaPerDay":2}}`)
+
+	var ticket Tickets
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1","A2","A3"],`+
+		`"amenities":[{"seatNumber":"X1","water":1},{"seatNumber":"X2","water":1},{"seatNumber":"X3","water":1}]}`), &ticket)
+	if len(ticket.Amenities) != 3 || ticket.Amenities[0].SeatNumber != "A1" {
+		t.Fatalf("%+v", ticket.Amenities)
+	}
+
+	l.fail(l.alice, "exchange_water", `{"ticketId":"`+ticket.TicketId+`","seats":["X1"]}`)
+	l.fail(l.bob, "exchange_water", `{"ticketId":"`+ticket.TicketId+`"}`)
+	var result ExchangeResult
+	json.Unmarshal(l.ok(l.alice, "exchange_water", `{"ticketId":"`+ticket.TicketId+`","seats":["A2"]}`), &result)
+	if len(result.Exchanged) != 1 || result.Exchanged[0] != "A2" || result.AvailableQty != 1 {
+		t.Fatalf("%+v", result)
+	}
+	l.fail(l.alice, "exchange_water", `{"ticketId":"`+ticket.TicketId+`","seats":["A2"]}`)
+
+	json.Unmarshal(l.ok(l.alice, "exchange_water", `{"ticketId":"`+ticket.TicketId+`"}`), &result)
+	if len(result.Exchanged) != 1 || result.Exchanged[0] != "A1" || len(result.NotExchanged) != 1 || result.AvailableQty != 0 {
+		t.Fatalf("%+v", result)
+	}
+	response := l.fail(l.alice, "exchange_water", `{"ticketId":"`+ticket.TicketId+`"}`)
+	if !strings.Contains(response.Message, ErrQuotaExhausted) {
+		t.Fatal(response.Message)
+	}
+}
+
+// Endorsing peers running the same booking proposal write the same ticket
+func TestTicketIdFromTheTransaction(t *testing.T) {
+	l := newTestLedger(t, 1, 2)
+	txs := l.txs
+	first := l.simulate(l.alice, l.now, "book_tickets", `{"showId":"S1","seats":["A1"]}`)
+	l.txs = txs
+	second := l.simulate(l.alice, l.now, "book_tickets", `{"showId":"S1","seats":["A1"]}`)
+	if !reflect.DeepEqual(first.writes, second.writes) {
+		t.Fatalf("%v\n%v", first.writes, second.writes)
+	}
+
+	var ticket Tickets
+	json.Unmarshal(first.response.Payload, &ticket)
+	if ticket.TicketId != "T"+txScopedId(first) {
+		t.Fatalf("%+v", ticket)
+	}
+}
+
+// A booking sent again with its requestId gives back the ticket booked the first time
+func TestBookingRequestId(t *testing.T) {
+	l := newTestLedger(t, 1, 3)
+	var first, retry Tickets
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1"],"requestId":"r1"}`), &first)
+	json.Unmarshal(l.ok(l.alice, "book_tickets", `{"showId":"S1","seats":["A1"],"requestId":"r1"}`), &retry)
+	if first.TicketId != retry.TicketId || first.RequestId != "r1" {
+		t.Fatalf("%+v %+v", first, retry)
+	}
+	response := l.fail(l.alice, "book_tickets", `{"showId":"S1","seats":["A2"],"requestId":"r1"}`)
+	if !strings.Contains(response.Message, "already used") {
+		t.Fatal(response.Message)
+	}
+	l.fail(l.alice, "book_tickets", `{"showId":"S1","seats":["A2"],"requestId":"`+strings.Repeat("r", maxRequestIdLength+1)+`"}`)
+
+	// requestIds of other buyers are their own
+	l.ok(l.bob, "book_tickets", `{"showId":"S1","seats":["A2"],"requestId":"r1"}`)
+	var show Shows
+	json.Unmarshal(l.ok(l.alice, "get_show", `{"showId":"S1"}`), &show)
+	if show.BookedSeat != 2 {
+		t.Fatalf("%+v", show)
+	}
+}